@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/miekg/dns"
+	"golang-dns/internal/model"
+	"golang-dns/internal/providers"
+	"golang-dns/internal/service"
+	t "golang-dns/internal/transverse"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// validationCacheSize bounds the cache of the answers queried by the validation and by its explanation.
+const validationCacheSize = 4 << 20
+
+// Resolve a name through the DoH pool, and print the chain of trust walked to validate its answer:
+// the DNSKEY set of each zone, the DS records matching them, the validity windows of the signatures,
+// the NSEC/NSEC3 records denying a DS or the name, and the step at which the validation failed.
+//
+//	dnssec-explain -type MX example.com
+func main() {
+
+	dnsType := flag.String("type", "A", "type of the question")
+	provider := flag.String("provider", "google", "DoH pool resolving the name: google, cloudflare, quad9 or global")
+	asJson := flag.Bool("json", false, "print the explanation as JSON")
+	verbose := flag.Bool("verbose", false, "log the steps of the validation to stderr")
+	anchorsFile := flag.String("anchors", "", "IANA trust anchors file, the root trust anchors of the server when empty")
+	anchorsPath := flag.String("anchors-path", service.DefaultAnchorsPath, "directory of the root trust anchors tracked by the server with RFC 5011")
+	policyRules := flag.String("dnssec-policy", "", "validation mode of domains, as given to the server, ex: example.com=skip,corp.example=enforce")
+	disableSha1 := flag.Bool("dnssec-disable-sha1", false, "stop validating the SHA-1 based DNSSEC algorithms and DS digests, the zones signed with them only are insecure")
+	replay := flag.String("replay", "", "answer with the responses recorded in this file rather than with the DoH pool")
+	record := flag.String("record", "", "record the responses of the DoH pool in this file, to replay them later")
+	at := flag.String("at", "", "time the signatures are verified at, RFC 3339, the host time when empty")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: dnssec-explain [flags] name\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	qtype, found := dns.StringToType[strings.ToUpper(*dnsType)]
+	if !found {
+		log.Fatalf("unknown type: %s", *dnsType)
+	}
+
+	// the output is reserved to the explanation.
+	t.Logger().SetOutput(io.Discard)
+	t.LoggerError().SetOutput(os.Stderr)
+	if *verbose {
+		t.Logger().SetOutput(os.Stderr)
+		t.FlagLogDnssec = true
+	}
+
+	if *disableSha1 {
+		service.SetDnssecAlgorithmPolicy(service.DefaultDnssecAlgorithmPolicy().WithoutSha1())
+	}
+
+	rules, err := service.ParseDnssecPolicy(*policyRules)
+	if err != nil {
+		log.Fatalf("invalid dnssec policy: %v", err)
+	}
+	policy, err := service.NewDnssecPolicy(rules...)
+	if err != nil {
+		log.Fatalf("invalid dnssec policy: %v", err)
+	}
+
+	var clock t.Clock = t.SystemClock{}
+	if *at != "" {
+		now, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("invalid time: %v", err)
+		}
+		clock = t.FixedClock(now)
+	}
+
+	var upstream service.DnsResolverProxy
+	var recorder *service.DnsResolverFixture
+
+	switch {
+	case *replay != "":
+		b, err := os.ReadFile(*replay)
+		if err != nil {
+			log.Fatalf("unable to read fixtures: %v", err)
+		}
+		recorded, err := model.NewDnsFixturesFromBytes(b)
+		if err != nil {
+			log.Fatalf("invalid fixtures: %v", err)
+		}
+		if upstream, err = service.NewDnsResolverFixture(recorded); err != nil {
+			log.Fatalf("invalid fixtures: %v", err)
+		}
+	default:
+		pool, err := newDnsPool(*provider)
+		if err != nil {
+			log.Fatal(err)
+		}
+		upstream = pool
+		if *record != "" {
+			recorder = service.NewDnsResolverRecorder(pool)
+			upstream = recorder
+		}
+	}
+
+	upstream = upstream.WithCacheStorage(service.DefaultDnsCacheConfig(), service.NewLru(validationCacheSize))
+
+	anchors, err := newTrustAnchors(upstream, *anchorsFile, *anchorsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	validator := service.NewDnssecValidatorWithAnchors(upstream, anchors).WithPolicy(policy).WithClock(clock)
+
+	rm, err := upstream.AsResolver().Query(dns.Fqdn(flag.Arg(0)), qtype)
+	if err != nil {
+		log.Fatalf("unable to resolve %s: %v", flag.Arg(0), err)
+	}
+
+	explanation := validator.Explain(rm)
+
+	if recorder != nil {
+		b, err := recorder.Fixtures().AsBytes()
+		if err == nil {
+			err = os.WriteFile(*record, b, 0644)
+		}
+		if err != nil {
+			log.Fatalf("unable to record fixtures: %v", err)
+		}
+	}
+
+	if *asJson {
+		b, err := explanation.AsBytes()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	} else {
+		fmt.Print(explanation)
+	}
+
+	if explanation.Failed != "" {
+		os.Exit(1)
+	}
+}
+
+// newTrustAnchors returns the anchors of the file if any, otherwise the root trust anchors of the server:
+// the embedded IANA anchors, updated with the rollovers tracked in its database.
+func newTrustAnchors(upstream service.DnsResolverProxy, file, path string) (service.DnssecTrustAnchors, error) {
+
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return service.DnssecTrustAnchors{}, fmt.Errorf("unable to read trust anchors: %v", err)
+		}
+		return service.NewDnssecTrustAnchors(service.LoadIanaFile(string(b))), nil
+	}
+
+	anchors := service.NewDnssecBootstrapAnchors()
+
+	// the server has not tracked any rollover yet.
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return anchors, nil
+	}
+
+	anchorsConf := service.DefaultAnchorsBadgerConfig()
+	anchorsConf.Path = path
+	anchorsConf.GCInterval = 0
+
+	db, err := service.NewBadgerWithConfig(anchorsConf)
+	if err != nil {
+		return anchors, fmt.Errorf("unable to open trust anchors, ex: locked by the running server, use -anchors: %v", err)
+	}
+	defer db.Close()
+
+	// the persisted anchors are restored, they are not refreshed.
+	service.NewDnssecAnchorTracker(upstream, anchors, db, service.DefaultDnssecAnchorTrackerConfig())
+
+	return anchors, nil
+}
+
+func newDnsPool(provider string) (service.DnsResolverProxy, error) {
+	switch provider {
+	case "google":
+		return providers.NewGoogleDnsPool(), nil
+	case "cloudflare":
+		return providers.NewCloudFlareDnsPool(), nil
+	case "quad9":
+		return providers.NewQuad9DnsPool(), nil
+	case "global":
+		return providers.NewGlobalDnsPool(), nil
+	}
+	return nil, fmt.Errorf("unknown provider: %s", provider)
+}
@@ -1,19 +1,165 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"golang-dns/internal/providers"
 	"golang-dns/internal/server"
 	"golang-dns/internal/service"
+	t "golang-dns/internal/transverse"
+	"golang.org/x/time/rate"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// validationCacheSize bounds the cache of the raw upstream answers used by the DNSSEC validator.
+const validationCacheSize = 4 << 20
+
 func main() {
 
-	resolver := providers.NewGoogleDnsPool().WithCache().WithDnssec().WithBadger(service.NewBadger()).WithLog().WithRateLimiting()
+	conf := service.DefaultDnsCacheConfig()
+	memoryConf := service.DefaultRistrettoConfig()
+	preloadConf := service.DefaultDnsCachePreloadConfig()
+	badgerConf := service.DefaultBadgerConfig()
+	anchorsConf := service.DefaultAnchorsBadgerConfig()
+	trackerConf := service.DefaultDnssecAnchorTrackerConfig()
+	rateLimitConf := service.DefaultDnsRateLimitingConfig()
+
+	cacheSize := flag.Int64("cache-size", memoryConf.MaxCost>>20, "maximum size of the in-memory cache, in MiB")
+	flag.DurationVar(&conf.MinTTL, "cache-min-ttl", conf.MinTTL, "minimum time an answer is kept in cache")
+	flag.DurationVar(&conf.MaxTTL, "cache-max-ttl", conf.MaxTTL, "maximum time an answer is kept in cache")
+	flag.StringVar(&badgerConf.Path, "badger-path", badgerConf.Path, "directory of the persistent cache")
+	badgerSize := flag.Int64("badger-max-size", badgerConf.MaxSize>>20, "maximum size of the persistent cache, in MiB, 0 for unlimited")
+	flag.BoolVar(&badgerConf.Recover, "badger-recover", badgerConf.Recover, "move a corrupted persistent cache aside and start with an empty one")
+	flag.StringVar(&anchorsConf.Path, "anchors-path", anchorsConf.Path, "directory of the root trust anchors tracked with RFC 5011")
+	flag.DurationVar(&trackerConf.Refresh, "anchors-refresh", trackerConf.Refresh, "interval between two refreshes of the root trust anchors")
+	policyRules := flag.String("dnssec-policy", "", "validation mode of domains, ex: example.com=skip,corp.example=enforce, modes: enforce, validate-if-signed, skip; the mode of the name of the question applies to the whole answer, CNAME targets included")
+	disableSha1 := flag.Bool("dnssec-disable-sha1", false, "stop validating the SHA-1 based DNSSEC algorithms and DS digests, the zones signed with them only are insecure")
+	aggressive := flag.Int("dnssec-aggressive-size", service.DefaultAggressiveCacheSize, "maximum number of validated NSEC/NSEC3 records synthesizing negative responses (RFC 8198), 0 to disable")
+	roughtime := flag.Bool("roughtime", true, "verify certificates and signatures with the time told by Roughtime servers rather than the host time")
+	admin := flag.String("admin", "127.0.0.1:8053", "loopback address of the cache administration API, empty to disable")
+	preload := flag.Bool("preload", true, "warm the cache up with the answers persisted by a previous run before serving queries")
+	preloadRate := flag.Float64("preload-rate", float64(preloadConf.Rate), "maximum number of upstream queries per second while preloading")
+	flag.IntVar(&preloadConf.Workers, "preload-workers", preloadConf.Workers, "number of concurrent upstream queries while preloading")
+	preloadTimeout := flag.Duration("preload-timeout", 30*time.Second, "maximum duration of the preload phase")
+	rateLimit := flag.Float64("rate-limit", float64(rateLimitConf.Rate), "maximum number of queries per second of a client, 0 for unlimited")
+	flag.IntVar(&rateLimitConf.Burst, "rate-burst", rateLimitConf.Burst, "number of queries a client may send at once above its rate")
+	subnetRateLimit := flag.Float64("rate-limit-subnet", float64(rateLimitConf.SubnetRate), "maximum number of queries per second of the clients of a /24 IPv4 or /56 IPv6 subnet, 0 for unlimited")
+	flag.IntVar(&rateLimitConf.SubnetBurst, "rate-burst-subnet", rateLimitConf.SubnetBurst, "number of queries the clients of a subnet may send at once above their rate")
+	flag.IntVar(&rateLimitConf.MaxClients, "rate-limit-clients", rateLimitConf.MaxClients, "number of clients and subnets tracked, the least recently seen ones are forgotten")
+	rateLimitAction := flag.String("rate-limit-action", rateLimitConf.Action.String(), "answer to the queries exceeding the rate limits: refuse, drop or truncate")
+	flag.Parse()
+
+	memoryConf.MaxCost = *cacheSize << 20
+	preloadConf.Rate = rate.Limit(*preloadRate)
+	badgerConf.MaxSize = *badgerSize << 20
+	rateLimitConf.Rate = rate.Limit(*rateLimit)
+	rateLimitConf.SubnetRate = rate.Limit(*subnetRateLimit)
+
+	action, err := service.ParseRateLimitAction(*rateLimitAction)
+	if err != nil {
+		log.Fatalf("invalid rate limit: %v", err)
+	}
+	rateLimitConf.Action = action
+
+	if *roughtime {
+		roughtimeConf := service.DefaultRoughtimeConfig()
+		roughtimeConf.Resolver = providers.NewGoogleDnsPool().AsResolver()
+		clock := service.NewRoughtimeClock(roughtimeConf)
+		if err := clock.Sync(); err != nil {
+			t.LoggerError().Printf("unable to synchronize roughtime clock, using the host time until the next attempt: %v", err)
+		}
+		t.SetClock(clock)
+		clock.ContinuouslySync()
+		defer clock.Close()
+	}
+
+	if *disableSha1 {
+		service.SetDnssecAlgorithmPolicy(service.DefaultDnssecAlgorithmPolicy().WithoutSha1())
+	}
+
+	rules, err := service.ParseDnssecPolicy(*policyRules)
+	if err != nil {
+		log.Fatalf("invalid dnssec policy: %v", err)
+	}
+	policy, err := service.NewDnssecPolicy(rules...)
+	if err != nil {
+		log.Fatalf("invalid dnssec policy: %v", err)
+	}
+
+	db, err := service.NewBadgerWithConfig(badgerConf)
+	if err != nil {
+		log.Fatalf("unable to open persistent cache: %v", err)
+	}
+	defer db.Close()
+
+	anchorsDb, err := service.NewBadgerWithConfig(anchorsConf)
+	if err != nil {
+		log.Fatalf("unable to open trust anchors: %v", err)
+	}
+	defer anchorsDb.Close()
+
+	// the validation cache holds unvalidated answers, it must never be shared with the validated tiers.
+	validation := service.NewLru(validationCacheSize)
+	memory := service.NewRistretto(memoryConf)
+	// the disk tier is written in the background, not to delay the queries.
+	disk := service.NewDnsCacheStorageAsync(db)
+
+	upstream := providers.NewGoogleDnsPool().
+		WithCacheStorage(conf, validation)
+
+	// the embedded IANA anchors bootstrap the first run, the root key rollovers are then tracked with RFC 5011.
+	anchors := service.NewDnssecBootstrapAnchors()
+	tracker := service.NewDnssecAnchorTracker(upstream, anchors, anchorsDb, trackerConf)
+	tracker.ContinuouslyRefresh()
+	defer tracker.Close()
+
+	validator := service.NewDnssecValidatorWithAnchors(upstream, anchors).WithPolicy(policy)
+	if *aggressive > 0 {
+		validator = validator.WithAggressiveCache(service.NewDnssecAggressiveCache(*aggressive))
+	}
+
+	resolver := upstream.
+		WithDnssecValidator(validator).
+		WithCacheStorage(conf, memory, disk).
+		WithLog().
+		WithRateLimitingConfig(rateLimitConf)
+
+	if *admin != "" {
+		go func() {
+			if err := server.StartAdmin(*admin, policy, service.NewDnsCacheAdmin(memory, validation, disk)); err != nil {
+				t.LoggerError().Printf("unable to run admin server: %v", err)
+			}
+		}()
+	}
+
+	if *preload {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		ctx, cancelTimeout := context.WithTimeout(ctx, *preloadTimeout)
+
+		p := service.NewDnsCachePreload(resolver, memory, db, preloadConf)
+		if err := p.Preload(ctx); err != nil {
+			t.LoggerError().Printf("preload not completed: %v", err)
+		}
+
+		cancelTimeout()
+		cancel()
+	}
+
+	t.SetReady()
 
 	//go func() { server.StartGin(resolver) }()
 
-	err := server.RunLocalUDPServer("udp4", ":53", resolver)
+	go func() {
+		if err := server.RunLocalTCPServer("tcp4", ":53", resolver); err != nil {
+			log.Fatalf("unable to run TCP server: %v", err)
+		}
+	}()
+
+	err = server.RunLocalUDPServer("udp4", ":53", resolver)
 	if err != nil {
 		log.Fatalf("unable to run server: %v", err)
 	}
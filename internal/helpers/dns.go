@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"github.com/miekg/dns"
+	"net"
 	"strings"
 )
 
@@ -16,6 +17,47 @@ func Msg(name string, dnsType, dnsClass uint16) *dns.Msg {
 	return m
 }
 
+// GetClientSubnet returns the EDNS client subnet of the message truncated to its source prefix, if any.
+func GetClientSubnet(m *dns.Msg) *net.IPNet {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, v := range o.Option {
+		if e, ok := v.(*dns.EDNS0_SUBNET); ok {
+			bits := 8 * net.IPv4len
+			if e.Family == 2 {
+				bits = 8 * net.IPv6len
+			}
+			mask := net.CIDRMask(int(e.SourceNetmask), bits)
+			if ip := e.Address.Mask(mask); ip != nil {
+				return &net.IPNet{IP: ip, Mask: mask}
+			}
+		}
+	}
+	return nil
+}
+
+// SetClientSubnet adds the EDNS client subnet option to the message.
+func SetClientSubnet(m *dns.Msg, subnet *net.IPNet) {
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(4096, false)
+		o = m.IsEdns0()
+	}
+	ones, bits := subnet.Mask.Size()
+	family := uint16(1)
+	if bits == 8*net.IPv6len {
+		family = 2
+	}
+	o.Option = append(o.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(ones),
+		Address:       subnet.IP,
+	})
+}
+
 func CollectAll(rrset []dns.RR, dnsType uint16) []dns.RR {
 	dnsKeys := make([]dns.RR, 0, 10)
 	for _, v := range rrset {
@@ -56,3 +98,62 @@ func SubZone(domain string, i int) string {
 
 	return builder.String()
 }
+
+// CanonicalCompare compares two domain names in the canonical DNS order of RFC 4034 §6.1,
+// it returns -1, 0 or +1 when a sorts before, equal or after b.
+func CanonicalCompare(a, b string) int {
+
+	la := dns.SplitDomainName(a)
+	lb := dns.SplitDomainName(b)
+
+	// compare labels from the rightmost one
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(canonicalLabel(la[i]), canonicalLabel(lb[j])); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	}
+	return 0
+}
+
+// CommonAncestor returns the longest domain name which is an ancestor of both names.
+func CommonAncestor(a, b string) string {
+	labels := dns.SplitDomainName(dns.Fqdn(a))
+	n := dns.CompareDomainName(a, b)
+	if n == 0 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// canonicalLabel decodes the escaped characters of the label and lowercases its ASCII letters.
+func canonicalLabel(label string) string {
+	b := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c == '\\' && i+1 < len(label) {
+			if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+				c = (label[i+1]-'0')*100 + (label[i+2]-'0')*10 + (label[i+3] - '0')
+				i += 3
+			} else {
+				c = label[i+1]
+				i++
+			}
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
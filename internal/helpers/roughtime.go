@@ -0,0 +1,275 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Roughtime protocol, as implemented by the Google, Cloudflare and int08h servers:
+// https://roughtime.googlesource.com/roughtime/+/HEAD/PROTOCOL.md
+
+const (
+	RoughtimeNonceSize   = 64
+	RoughtimeRequestSize = 1024 // requests are padded, so that the responses never amplify them.
+
+	roughtimeCertificateContext = "RoughTime v1 delegation signature--\x00"
+	roughtimeResponseContext    = "RoughTime v1 response signature\x00"
+)
+
+var (
+	TagSIG  = roughtimeTag("SIG\x00")
+	TagNONC = roughtimeTag("NONC")
+	TagDELE = roughtimeTag("DELE")
+	TagPATH = roughtimeTag("PATH")
+	TagRADI = roughtimeTag("RADI")
+	TagPUBK = roughtimeTag("PUBK")
+	TagMIDP = roughtimeTag("MIDP")
+	TagSREP = roughtimeTag("SREP")
+	TagMINT = roughtimeTag("MINT")
+	TagROOT = roughtimeTag("ROOT")
+	TagCERT = roughtimeTag("CERT")
+	TagMAXT = roughtimeTag("MAXT")
+	TagINDX = roughtimeTag("INDX")
+	TagPAD  = roughtimeTag("PAD\xff")
+)
+
+func roughtimeTag(s string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(s))
+}
+
+// EncodeRoughtimeMessage encodes a message: the number of tags, the offsets of the values, the sorted tags, then the values.
+func EncodeRoughtimeMessage(msg map[uint32][]byte) ([]byte, error) {
+
+	tags := make([]uint32, 0, len(msg))
+	for tag, value := range msg {
+		if len(value)%4 != 0 {
+			return nil, fmt.Errorf("roughtime value of tag %x is not a multiple of 4 bytes", tag)
+		}
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(tags)))
+
+	offset := uint32(0)
+	for i, tag := range tags {
+		if i > 0 {
+			_ = binary.Write(&b, binary.LittleEndian, offset)
+		}
+		offset += uint32(len(msg[tag]))
+	}
+	for _, tag := range tags {
+		_ = binary.Write(&b, binary.LittleEndian, tag)
+	}
+	for _, tag := range tags {
+		b.Write(msg[tag])
+	}
+
+	return b.Bytes(), nil
+}
+
+// DecodeRoughtimeMessage decodes a message, rejecting unsorted tags and out of bounds offsets.
+func DecodeRoughtimeMessage(b []byte) (map[uint32][]byte, error) {
+
+	if len(b) < 4 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("invalid roughtime message size: %d", len(b))
+	}
+
+	n := int(binary.LittleEndian.Uint32(b))
+	if n == 0 {
+		return map[uint32][]byte{}, nil
+	}
+
+	header := 4 * (1 + (n - 1) + n)
+	if n > len(b)/8 || header > len(b) {
+		return nil, fmt.Errorf("invalid roughtime message: %d tags in %d bytes", n, len(b))
+	}
+
+	values := b[header:]
+	offsets := make([]int, n+1)
+	for i := 1; i < n; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	offsets[n] = len(values)
+
+	msg := make(map[uint32][]byte, n)
+	previous := uint32(0)
+	for i := 0; i < n; i++ {
+
+		tag := binary.LittleEndian.Uint32(b[4*(n+i):])
+		if i > 0 && tag <= previous {
+			return nil, fmt.Errorf("invalid roughtime message: unsorted tags")
+		}
+		previous = tag
+
+		start, end := offsets[i], offsets[i+1]
+		if start%4 != 0 || start > end || end > len(values) {
+			return nil, fmt.Errorf("invalid roughtime message: invalid offset of tag %x", tag)
+		}
+
+		msg[tag] = values[start:end]
+	}
+
+	return msg, nil
+}
+
+// NewRoughtimeRequest returns a request of the time for nonce, padded to the request size.
+func NewRoughtimeRequest(nonce []byte) ([]byte, error) {
+
+	if len(nonce) != RoughtimeNonceSize {
+		return nil, fmt.Errorf("roughtime nonce must be %d bytes", RoughtimeNonceSize)
+	}
+
+	// header: number of tags, one offset, two tags.
+	padding := RoughtimeRequestSize - 4*4 - RoughtimeNonceSize
+
+	return EncodeRoughtimeMessage(map[uint32][]byte{
+		TagNONC: nonce,
+		TagPAD:  make([]byte, padding),
+	})
+}
+
+// RoughtimeReply is the time told by a server: the true time is within Midpoint ± Radius.
+type RoughtimeReply struct {
+	Midpoint time.Time
+	Radius   time.Duration
+}
+
+// VerifyRoughtimeResponse verifies that the response is signed by a key delegated by the long-term key of the server,
+// that it answers the nonce, and that the delegation is valid at the time told.
+func VerifyRoughtimeResponse(b []byte, nonce []byte, publicKey ed25519.PublicKey) (RoughtimeReply, error) {
+
+	var reply RoughtimeReply
+
+	msg, err := DecodeRoughtimeMessage(b)
+	if err != nil {
+		return reply, err
+	}
+
+	cert, err := roughtimeNested(msg, TagCERT)
+	if err != nil {
+		return reply, err
+	}
+	delegation, err := roughtimeNested(cert, TagDELE)
+	if err != nil {
+		return reply, err
+	}
+
+	// the delegated key is signed by the long-term key of the server.
+	if !ed25519.Verify(publicKey, append([]byte(roughtimeCertificateContext), cert[TagDELE]...), cert[TagSIG]) {
+		return reply, fmt.Errorf("invalid roughtime delegation signature")
+	}
+
+	delegated := delegation[TagPUBK]
+	if len(delegated) != ed25519.PublicKeySize {
+		return reply, fmt.Errorf("invalid roughtime delegated key")
+	}
+
+	// the signed response is signed by the delegated key.
+	srep, err := roughtimeNested(msg, TagSREP)
+	if err != nil {
+		return reply, err
+	}
+	if !ed25519.Verify(delegated, append([]byte(roughtimeResponseContext), msg[TagSREP]...), msg[TagSIG]) {
+		return reply, fmt.Errorf("invalid roughtime response signature")
+	}
+
+	// the nonce belongs to the tree of the requests answered by the signed response.
+	if err := verifyRoughtimePath(nonce, msg[TagINDX], msg[TagPATH], srep[TagROOT]); err != nil {
+		return reply, err
+	}
+
+	midpoint, err := roughtimeUint64(srep, TagMIDP)
+	if err != nil {
+		return reply, err
+	}
+	radius, err := roughtimeUint32(srep, TagRADI)
+	if err != nil {
+		return reply, err
+	}
+	minTime, err := roughtimeUint64(delegation, TagMINT)
+	if err != nil {
+		return reply, err
+	}
+	maxTime, err := roughtimeUint64(delegation, TagMAXT)
+	if err != nil {
+		return reply, err
+	}
+
+	if midpoint < minTime || midpoint > maxTime {
+		return reply, fmt.Errorf("roughtime delegation is not valid at the time told")
+	}
+
+	reply.Midpoint = time.UnixMicro(int64(midpoint))
+	reply.Radius = time.Duration(radius) * time.Microsecond
+
+	return reply, nil
+}
+
+func verifyRoughtimePath(nonce, index, path, root []byte) error {
+
+	if len(index) != 4 || len(path)%sha512.Size != 0 || len(root) != sha512.Size {
+		return fmt.Errorf("invalid roughtime merkle tree")
+	}
+
+	i := binary.LittleEndian.Uint32(index)
+	hash := RoughtimeLeafHash(nonce)
+
+	for ; len(path) > 0; path = path[sha512.Size:] {
+		if i&1 == 0 {
+			hash = RoughtimeNodeHash(hash, path[:sha512.Size])
+		} else {
+			hash = RoughtimeNodeHash(path[:sha512.Size], hash)
+		}
+		i >>= 1
+	}
+
+	if !bytes.Equal(hash, root) {
+		return fmt.Errorf("roughtime nonce is not answered")
+	}
+
+	return nil
+}
+
+func RoughtimeLeafHash(leaf []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func RoughtimeNodeHash(left, right []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+func roughtimeNested(msg map[uint32][]byte, tag uint32) (map[uint32][]byte, error) {
+	value, found := msg[tag]
+	if !found {
+		return nil, fmt.Errorf("roughtime tag %x is missing", tag)
+	}
+	return DecodeRoughtimeMessage(value)
+}
+
+func roughtimeUint64(msg map[uint32][]byte, tag uint32) (uint64, error) {
+	if v := msg[tag]; len(v) == 8 {
+		return binary.LittleEndian.Uint64(v), nil
+	}
+	return 0, fmt.Errorf("invalid roughtime tag %x", tag)
+}
+
+func roughtimeUint32(msg map[uint32][]byte, tag uint32) (uint32, error) {
+	if v := msg[tag]; len(v) == 4 {
+		return binary.LittleEndian.Uint32(v), nil
+	}
+	return 0, fmt.Errorf("invalid roughtime tag %x", tag)
+}
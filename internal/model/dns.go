@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/miekg/dns"
 	h "golang-dns/internal/helpers"
+	"net"
+	"strings"
 	"time"
 )
 
@@ -12,17 +14,20 @@ const (
 )
 
 type DnsMsg struct {
-	m *dns.Msg
+	m      *dns.Msg
+	dnssec DnssecResult // outcome of the DNSSEC validation of the message.
+	client net.IP       // address of the client which sent the query, nil for the queries of the server itself.
+	stream bool         // the query was received over TCP or HTTPS, its response is never truncated.
 }
 
 func NewDnsMsg(m *dns.Msg) DnsMsg {
 	return DnsMsg{m: m}
 }
 
+// WithDNSSEC requests the DNSSEC records of the answer, the checking disabled bit of the client is kept as is.
 func (r DnsMsg) WithDNSSEC() DnsMsg {
 
 	r.m.RecursionDesired = true // +rev
-	r.m.CheckingDisabled = false
 
 	o := r.m.IsEdns0()
 	if o == nil {
@@ -43,6 +48,57 @@ func (r DnsMsg) GetTTL() time.Duration {
 	return defaultTTL
 }
 
+// WithTTL caps the TTL of every record of the message to ttl.
+func (r DnsMsg) WithTTL(ttl time.Duration) DnsMsg {
+	seconds := uint32(ttl / time.Second)
+	for _, section := range [][]dns.RR{r.m.Answer, r.m.Ns, r.m.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT && h.Ttl > seconds {
+				h.Ttl = seconds
+			}
+		}
+	}
+	return r
+}
+
+// AsValidated marks the message as verified by DNSSEC.
+func (r DnsMsg) AsValidated() DnsMsg {
+	return r.WithDnssecResult(DnssecResult{Status: DnssecSecure})
+}
+
+func (r DnsMsg) IsValidated() bool {
+	return r.dnssec.Status == DnssecSecure
+}
+
+func (r DnsMsg) WithDnssecResult(result DnssecResult) DnsMsg {
+	r.dnssec = result
+	return r
+}
+
+func (r DnsMsg) GetDnssecResult() DnssecResult {
+	return r.dnssec
+}
+
+// WithClient attaches the address of the client which sent the query.
+func (r DnsMsg) WithClient(ip net.IP) DnsMsg {
+	r.client = ip
+	return r
+}
+
+func (r DnsMsg) GetClient() net.IP {
+	return r.client
+}
+
+// WithStream tells that the query was received over a stream transport, TCP or HTTPS.
+func (r DnsMsg) WithStream() DnsMsg {
+	r.stream = true
+	return r
+}
+
+func (r DnsMsg) IsStream() bool {
+	return r.stream
+}
+
 func (r DnsMsg) GetQuestion() dns.Question {
 	return r.m.Question[0]
 }
@@ -102,11 +158,14 @@ func (r DnsMsg) GetRRSIG() []*dns.RRSIG {
 	return rrsig
 }
 
-func (r DnsMsg) GetDS() *dns.DS {
-	if rr := h.CollectOne(r.m.Answer, dns.TypeDS); rr != nil {
-		return rr.(*dns.DS)
+func (r DnsMsg) GetDS() []*dns.DS {
+	ds := make([]*dns.DS, 0, 4)
+	for _, v := range r.m.Answer {
+		if v.Header().Rrtype == dns.TypeDS {
+			ds = append(ds, v.(*dns.DS))
+		}
 	}
-	return nil
+	return ds
 }
 
 func (r DnsMsg) GetNSEC3() []*dns.NSEC3 {
@@ -119,14 +178,98 @@ func (r DnsMsg) GetNSEC3() []*dns.NSEC3 {
 	return nsec
 }
 
+func (r DnsMsg) GetNSEC() []*dns.NSEC {
+	nsec := make([]*dns.NSEC, 0, 10)
+	for _, v := range r.m.Ns {
+		if v.Header().Rrtype == dns.TypeNSEC {
+			nsec = append(nsec, v.(*dns.NSEC))
+		}
+	}
+	return nsec
+}
+
+// GetSoaZone returns the zone of the authority section: the signer of the SOA record, or its owner when unsigned.
+func (r DnsMsg) GetSoaZone() string {
+	for _, v := range r.m.Ns {
+		if t, ok := v.(*dns.RRSIG); ok && t.TypeCovered == dns.TypeSOA {
+			return t.SignerName
+		}
+	}
+	if rr := h.CollectOne(r.m.Ns, dns.TypeSOA); rr != nil {
+		return rr.Header().Name
+	}
+	return ""
+}
+
 func (r DnsMsg) IsRRSIG() bool {
 	return len(r.GetRRSIG()) > 0
 }
 
+// IsNegative tells if the message is a NXDOMAIN or a NODATA response.
+func (r DnsMsg) IsNegative() bool {
+	return r.m.Rcode == dns.RcodeNameError || (r.m.Rcode == dns.RcodeSuccess && len(r.m.Answer) == 0)
+}
+
 func (r DnsMsg) IsEmpty() bool {
-	return r.m.Answer == nil
+	return len(r.m.Answer) == 0
 }
 
 func (r DnsMsg) String() string {
 	return fmt.Sprintf("AsyncDnsMsg: %s", r.m)
 }
+
+// DnsRRset groups the records of a section sharing the same owner name and type, along with their signatures.
+type DnsRRset struct {
+	Name       string
+	Type       uint16
+	RR         []dns.RR
+	Signatures []*dns.RRSIG
+}
+
+// NewDnsRRsets splits a section into RRsets, in their order of appearance.
+// Signatures which do not cover any RRset of the section are ignored.
+func NewDnsRRsets(section []dns.RR) []DnsRRset {
+
+	rrsets := make([]DnsRRset, 0, len(section))
+	index := make(map[string]int)
+
+	for _, v := range section {
+		if v.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		k := rrsetKey(v.Header().Name, v.Header().Rrtype)
+		if i, found := index[k]; found {
+			rrsets[i].RR = append(rrsets[i].RR, v)
+			continue
+		}
+		index[k] = len(rrsets)
+		rrsets = append(rrsets, DnsRRset{Name: v.Header().Name, Type: v.Header().Rrtype, RR: []dns.RR{v}})
+	}
+
+	for _, v := range section {
+		if rrsig, ok := v.(*dns.RRSIG); ok {
+			if i, found := index[rrsetKey(rrsig.Hdr.Name, rrsig.TypeCovered)]; found {
+				rrsets[i].Signatures = append(rrsets[i].Signatures, rrsig)
+			}
+		}
+	}
+
+	return rrsets
+}
+
+func rrsetKey(name string, rrtype uint16) string {
+	return fmt.Sprintf("%s/%d", strings.ToLower(name), rrtype)
+}
+
+// AsRR returns the records of the RRset followed by their signatures.
+func (s DnsRRset) AsRR() []dns.RR {
+	arr := append(make([]dns.RR, 0, len(s.RR)+len(s.Signatures)), s.RR...)
+	for _, rrsig := range s.Signatures {
+		arr = append(arr, rrsig)
+	}
+	return arr
+}
+
+func (s DnsRRset) String() string {
+	return fmt.Sprintf("%s %s", s.Name, dns.TypeToString[s.Type])
+}
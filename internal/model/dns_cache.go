@@ -1,63 +1,229 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/miekg/dns"
 	h "golang-dns/internal/helpers"
+	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	keyNone = "-"
+	keyDO   = "do"
+	keyCD   = "cd"
+)
+
+// DnsCacheKey identifies a cached response: name/qtype/qclass/dnssec/ecs
+//
+//	dnssec is "-", "do", "cd" or "do+cd" depending on the flags of the query.
+//	ecs is "-" or the EDNS client subnet of the query, truncated to its source prefix.
+//
+// Keys stored before the flags were part of the key only hold name/qtype/qclass.
 type DnsCacheKey string
 
 func (e DnsCacheKey) ToDnsMsg() DnsMsg {
 
-	r := strings.Split(string(e), "/")
+	r := strings.SplitN(string(e), "/", 5)
 	n := r[0]
 	t, _ := strconv.Atoi(r[1])
 	clazz, _ := strconv.Atoi(r[2])
 
 	msg := NewDnsMsg(h.Msg(n, uint16(t), uint16(clazz)))
+
+	if e.IsLegacy() {
+		return msg // legacy entries were always queried with +dnssec
+	}
+
+	m := msg.GetMsg()
+	flags := strings.Split(r[3], "+")
+	m.IsEdns0().SetDo(contains(flags, keyDO))
+	m.CheckingDisabled = contains(flags, keyCD)
+
+	if r[4] != keyNone {
+		if _, subnet, err := net.ParseCIDR(r[4]); err == nil {
+			h.SetClientSubnet(m, subnet)
+		}
+	}
+
 	return msg
 }
 
+// IsLegacy tells if the key was built without the dnssec flags and the client subnet.
+func (e DnsCacheKey) IsLegacy() bool {
+	return strings.Count(string(e), "/") == 2
+}
+
+// Migrate converts a legacy key to the current format.
+func (e DnsCacheKey) Migrate() DnsCacheKey {
+	return DnsCacheKey(NewDnsCacheKey(e.ToDnsMsg()))
+}
+
+// Name returns the domain name of the key.
+func (e DnsCacheKey) Name() string {
+	return strings.SplitN(string(e), "/", 2)[0]
+}
+
+// Matches tells if the key is about the given name, or any of its sub-domains when suffix is set.
+// An empty name matches every key.
+func (e DnsCacheKey) Matches(name string, suffix bool) bool {
+	if name == "" {
+		return true
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	if suffix {
+		return dns.IsSubDomain(name, e.Name())
+	}
+	return e.Name() == name
+}
+
 func NewDnsCacheKey(msg DnsMsg) string {
 	q := msg.GetQuestion()
-	return fmt.Sprintf("%s/%d/%d", q.Name, q.Qtype, q.Qclass)
+	m := msg.GetMsg()
+	return fmt.Sprintf("%s/%d/%d/%s/%s", strings.ToLower(q.Name), q.Qtype, q.Qclass, dnssecKey(m), subnetKey(m))
+}
+
+func dnssecKey(m *dns.Msg) string {
+	flags := make([]string, 0, 2)
+	if o := m.IsEdns0(); o != nil && o.Do() {
+		flags = append(flags, keyDO)
+	}
+	if m.CheckingDisabled {
+		flags = append(flags, keyCD)
+	}
+	if len(flags) == 0 {
+		return keyNone
+	}
+	return strings.Join(flags, "+")
+}
+
+func subnetKey(m *dns.Msg) string {
+	if subnet := h.GetClientSubnet(m); subnet != nil {
+		return subnet.String()
+	}
+	return keyNone
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 /********************/
 
-type DnsRistrettoEntry struct {
-	msg []byte
+// DnsCacheEntry is a packed response along with its expiry and DNSSEC validation state.
+type DnsCacheEntry struct {
+	msg    []byte
+	ttl    time.Duration
+	expire time.Time
+	dnssec DnssecStatus
+	hits   *uint32
 }
 
-func NewDnsRistrettoEntry(m DnsMsg) (DnsRistrettoEntry, error) {
-	var entry DnsRistrettoEntry
+type dnsCacheRecord struct {
+	Msg       []byte        `json:"msg"`
+	TTL       time.Duration `json:"ttl"`
+	Expire    time.Time     `json:"expire"`
+	Validated bool          `json:"validated"` // kept for the entries persisted before the DNSSEC status.
+	Dnssec    DnssecStatus  `json:"dnssec,omitempty"`
+}
+
+func NewDnsCacheEntry(m DnsMsg, ttl time.Duration) (DnsCacheEntry, error) {
+	var entry DnsCacheEntry
 	msg, err := m.GetMsg().Pack()
 	entry.msg = msg
+	entry.ttl = ttl
+	entry.expire = time.Now().Add(ttl)
+	entry.dnssec = m.GetDnssecResult().Status
+	entry.hits = new(uint32)
 	return entry, err
 }
 
-func (e DnsRistrettoEntry) Value() (DnsMsg, error) {
+// NewDnsCacheEntryFromBytes decodes a persisted entry.
+// Legacy entries only hold the packed message, they are considered as expired.
+func NewDnsCacheEntryFromBytes(b []byte) DnsCacheEntry {
+	var record dnsCacheRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return DnsCacheEntry{msg: b, hits: new(uint32)}
+	}
+	if record.Validated && record.Dnssec == DnssecIndeterminate {
+		record.Dnssec = DnssecSecure
+	}
+	return DnsCacheEntry{msg: record.Msg, ttl: record.TTL, expire: record.Expire, dnssec: record.Dnssec, hits: new(uint32)}
+}
+
+func (e DnsCacheEntry) AsBytes() ([]byte, error) {
+	return json.Marshal(dnsCacheRecord{Msg: e.msg, TTL: e.ttl, Expire: e.expire, Validated: e.dnssec == DnssecSecure, Dnssec: e.dnssec})
+}
+
+// Cost returns the size in bytes of the packed message.
+func (e DnsCacheEntry) Cost() int64 {
+	return int64(len(e.msg))
+}
+
+// TTL returns the time to live the entry was stored with.
+func (e DnsCacheEntry) TTL() time.Duration {
+	return e.ttl
+}
+
+// Remaining returns the remaining time to live of the entry, negative once expired.
+func (e DnsCacheEntry) Remaining() time.Duration {
+	return time.Until(e.expire)
+}
+
+func (e DnsCacheEntry) IsExpired() bool {
+	return e.Remaining() <= 0
+}
+
+// Hit records a cache hit on the entry and returns the number of hits so far.
+func (e DnsCacheEntry) Hit() uint32 {
+	return atomic.AddUint32(e.hits, 1)
+}
+
+func (e DnsCacheEntry) Value() (DnsMsg, error) {
 	in := new(dns.Msg)
 	err := in.Unpack(e.msg)
-	return NewDnsMsg(in), err
+	m := NewDnsMsg(in).WithDnssecResult(DnssecResult{Status: e.dnssec})
+	return m, err
 }
 
 /********************/
 
-type DnsBadgerEntry struct {
-	msg []byte
-}
+const (
+	DnssecValidated = "validated"
+	DnssecSigned    = "signed"
+	DnssecUnsigned  = "unsigned"
+)
 
-func NewDnsBadgerEntry(m DnsMsg) (DnsBadgerEntry, error) {
-	var entry DnsBadgerEntry
-	msg, err := m.GetMsg().Pack()
-	entry.msg = msg
-	return entry, err
+// DnsCacheEntryInfo describes a cached entry for administration purposes.
+type DnsCacheEntryInfo struct {
+	Backend string `json:"backend"`
+	Key     string `json:"key"`
+	TTL     int64  `json:"ttl"` // remaining time to live, in seconds.
+	Dnssec  string `json:"dnssec"`
 }
 
-func (e DnsBadgerEntry) AsBytes() []byte {
-	return e.msg
+func NewDnsCacheEntryInfo(backend, key string, ttl time.Duration, m DnsMsg) DnsCacheEntryInfo {
+	info := DnsCacheEntryInfo{
+		Backend: backend,
+		Key:     key,
+		TTL:     int64(ttl.Round(time.Second) / time.Second),
+		Dnssec:  DnssecUnsigned,
+	}
+	if m.IsValidated() {
+		info.Dnssec = DnssecValidated
+	} else if m.GetDnssecResult().Status == DnssecInsecure {
+		info.Dnssec = DnssecInsecure.String()
+	} else if m.IsRRSIG() {
+		info.Dnssec = DnssecSigned
+	}
+	return info
 }
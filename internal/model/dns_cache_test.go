@@ -0,0 +1,108 @@
+package model
+
+import (
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDnsCacheKey(t *testing.T) {
+
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+
+	withCD := h.Msg("Example.COM", dns.TypeA, dns.ClassINET)
+	withCD.CheckingDisabled = true
+
+	withoutDO := h.Msg("example.com", dns.TypeA, dns.ClassINET)
+	withoutDO.IsEdns0().SetDo(false)
+
+	withECS := h.Msg("example.com", dns.TypeAAAA, dns.ClassINET)
+	h.SetClientSubnet(withECS, subnet)
+
+	tests := []struct {
+		m      *dns.Msg
+		expect string
+	}{
+		{h.Msg("Example.COM", dns.TypeA, dns.ClassINET), "example.com./1/1/do/-"},
+		{withCD, "example.com./1/1/do+cd/-"},
+		{withoutDO, "example.com./1/1/-/-"},
+		{withECS, "example.com./28/1/do/192.0.2.0/24"},
+	}
+
+	for _, tt := range tests {
+		key := NewDnsCacheKey(NewDnsMsg(tt.m))
+		if key != tt.expect {
+			t.Fatalf("expect %s, got %s", tt.expect, key)
+		}
+		// the key must survive a round trip through ToDnsMsg
+		if actual := NewDnsCacheKey(DnsCacheKey(key).ToDnsMsg()); actual != key {
+			t.Fatalf("expect %s, got %s", key, actual)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCacheKeyMigrate(t *testing.T) {
+
+	legacy := DnsCacheKey("example.com./1/1")
+	if !legacy.IsLegacy() {
+		t.Fatalf("expect legacy key")
+	}
+
+	if actual := legacy.Migrate(); actual != "example.com./1/1/do/-" {
+		t.Fatalf("got wrong key %s", actual)
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCacheEntry(t *testing.T) {
+
+	m := h.Msg("example.com", dns.TypeA, dns.ClassINET)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(127, 0, 0, 1),
+	}}
+
+	entry, err := NewDnsCacheEntry(NewDnsMsg(m).AsValidated(), 300*time.Second)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	b, err := entry.AsBytes()
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	decoded := NewDnsCacheEntryFromBytes(b)
+	if decoded.IsExpired() || decoded.TTL() != 300*time.Second || decoded.Remaining() < 299*time.Second {
+		t.Fatalf("got wrong TTL %s", decoded.Remaining())
+	}
+
+	value, err := decoded.Value()
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if !value.IsValidated() || len(value.GetRR()) != 1 {
+		t.Fatalf("got wrong value %v", value)
+	}
+
+	if ttl := value.WithTTL(60 * time.Second).GetTTL(); ttl != 60*time.Second {
+		t.Fatalf("got wrong TTL %s", ttl)
+	}
+
+	// legacy entries only hold the packed message
+	packed, _ := m.Pack()
+	legacy := NewDnsCacheEntryFromBytes(packed)
+	if !legacy.IsExpired() {
+		t.Fatalf("legacy entry must be expired")
+	}
+	if value, err = legacy.Value(); err != nil || value.IsValidated() {
+		t.Fatalf("got wrong legacy value %v %v", value, err)
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+)
+
+// DnsFixture is a recorded response, its records in presentation format so that it can be read and edited.
+type DnsFixture struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Rcode  string   `json:"rcode"`
+	Answer []string `json:"answer,omitempty"`
+	Ns     []string `json:"ns,omitempty"`
+	Extra  []string `json:"extra,omitempty"`
+}
+
+// NewDnsFixture records the response, the OPT record excepted.
+func NewDnsFixture(m DnsMsg) DnsFixture {
+
+	q := m.GetQuestion()
+
+	records := func(section []dns.RR) []string {
+		arr := make([]string, 0, len(section))
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				arr = append(arr, rr.String())
+			}
+		}
+		return arr
+	}
+
+	return DnsFixture{
+		Name:   strings.ToLower(q.Name),
+		Type:   dns.TypeToString[q.Qtype],
+		Rcode:  dns.RcodeToString[m.GetMsg().Rcode],
+		Answer: records(m.GetMsg().Answer),
+		Ns:     records(m.GetMsg().Ns),
+		Extra:  records(m.GetMsg().Extra),
+	}
+}
+
+// Key returns the question answered by the fixture: name/TYPE.
+func (f DnsFixture) Key() string {
+	return DnsFixtureKey(f.Name, dns.StringToType[f.Type])
+}
+
+func DnsFixtureKey(name string, dnsType uint16) string {
+	return strings.ToLower(dns.Fqdn(name)) + "/" + dns.TypeToString[dnsType]
+}
+
+// Msg parses the recorded response.
+func (f DnsFixture) Msg() (*dns.Msg, error) {
+
+	dnsType, found := dns.StringToType[f.Type]
+	if !found {
+		return nil, fmt.Errorf("fixture %s: unknown type %s", f.Name, f.Type)
+	}
+	rcode, found := dns.StringToRcode[f.Rcode]
+	if !found {
+		return nil, fmt.Errorf("fixture %s: unknown rcode %s", f.Name, f.Rcode)
+	}
+
+	records := func(section []string) ([]dns.RR, error) {
+		arr := make([]dns.RR, 0, len(section))
+		for _, s := range section {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s/%s: %w", f.Name, f.Type, err)
+			}
+			arr = append(arr, rr)
+		}
+		return arr, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(f.Name), dnsType)
+	m.Response = true
+	m.Rcode = rcode
+
+	var err error
+	if m.Answer, err = records(f.Answer); err != nil {
+		return nil, err
+	}
+	if m.Ns, err = records(f.Ns); err != nil {
+		return nil, err
+	}
+	if m.Extra, err = records(f.Extra); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// DnsFixtures is a set of recorded responses.
+type DnsFixtures []DnsFixture
+
+func NewDnsFixturesFromBytes(b []byte) (DnsFixtures, error) {
+	var f DnsFixtures
+	err := json.Unmarshal(b, &f)
+	return f, err
+}
+
+// AsBytes returns the fixtures sorted by question, indented so that their changes can be reviewed.
+func (f DnsFixtures) AsBytes() ([]byte, error) {
+	sorted := append(DnsFixtures{}, f...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key() < sorted[j].Key()
+	})
+	return json.MarshalIndent(sorted, "", "  ")
+}
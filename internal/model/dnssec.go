@@ -0,0 +1,41 @@
+package model
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+)
+
+// DnssecStatus is the security status of a response (RFC 4035 §4.3).
+type DnssecStatus int
+
+const (
+	DnssecIndeterminate DnssecStatus = iota // not validated, or the validation could not complete.
+	DnssecSecure                            // the chain of trust has been verified.
+	DnssecInsecure                          // the chain of trust proves the zone is not signed.
+	DnssecBogus                             // the validation failed.
+)
+
+func (s DnssecStatus) String() string {
+	switch s {
+	case DnssecSecure:
+		return "secure"
+	case DnssecInsecure:
+		return "insecure"
+	case DnssecBogus:
+		return "bogus"
+	}
+	return "indeterminate"
+}
+
+// DnssecResult is the outcome of the validation of a response.
+type DnssecResult struct {
+	Status DnssecStatus
+	Ede    *dns.EDNS0_EDE // extended DNS error explaining a failed validation (RFC 8914), nil otherwise.
+}
+
+func (r DnssecResult) String() string {
+	if r.Ede == nil {
+		return r.Status.String()
+	}
+	return fmt.Sprintf("%s (%s: %s)", r.Status, dns.ExtendedErrorCodeToString[r.Ede.InfoCode], r.Ede.ExtraText)
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/miekg/dns"
+	"time"
+)
+
+// TrustAnchorState is the state of a trust anchor tracked with RFC 5011 (§4.2).
+type TrustAnchorState string
+
+const (
+	TrustAnchorAddPend TrustAnchorState = "AddPend" // new key, waiting for the add hold-down to expire.
+	TrustAnchorValid   TrustAnchorState = "Valid"   // trusted key.
+	TrustAnchorMissing TrustAnchorState = "Missing" // trusted key, absent from the last DNSKEY set.
+	TrustAnchorRevoked TrustAnchorState = "Revoked" // key revoked by its owner, never trusted again.
+)
+
+// TrustAnchor is a key-signing key of a zone, along with its RFC 5011 state.
+type TrustAnchor struct {
+	Key   string           `json:"key"`   // DNSKEY in presentation format, without the REVOKE flag.
+	State TrustAnchorState `json:"state"` // current state of the key.
+	Since time.Time        `json:"since"` // time of the last change of state.
+}
+
+// DNSKEY parses the key of the trust anchor.
+func (a TrustAnchor) DNSKEY() (*dns.DNSKEY, error) {
+	rr, err := dns.NewRR(a.Key)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("not a DNSKEY: %s", a.Key)
+	}
+	return key, nil
+}
+
+// IsTrusted tells whether the key can be used to validate the zone.
+func (a TrustAnchor) IsTrusted() bool {
+	return a.State == TrustAnchorValid || a.State == TrustAnchorMissing
+}
+
+// TrustAnchors is the set of tracked trust anchors of a zone.
+type TrustAnchors struct {
+	Zone    string        `json:"zone"`
+	Anchors []TrustAnchor `json:"anchors"`
+	Updated time.Time     `json:"updated"` // time of the last successful refresh.
+}
+
+func NewTrustAnchorsFromBytes(b []byte) (TrustAnchors, error) {
+	var a TrustAnchors
+	err := json.Unmarshal(b, &a)
+	return a, err
+}
+
+func (a TrustAnchors) AsBytes() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// KeyDigests returns the SHA-256 digests of the trusted keys.
+func (a TrustAnchors) KeyDigests() []IanaKeyDigest {
+	arr := make([]IanaKeyDigest, 0, len(a.Anchors))
+	for _, anchor := range a.Anchors {
+		if !anchor.IsTrusted() {
+			continue
+		}
+		key, err := anchor.DNSKEY()
+		if err != nil {
+			continue
+		}
+		ds := key.ToDS(dns.SHA256)
+		arr = append(arr, IanaKeyDigest{
+			KeyTag:     ds.KeyTag,
+			Algorithm:  ds.Algorithm,
+			DigestType: ds.DigestType,
+			Digest:     ds.Digest,
+		})
+	}
+	return arr
+}
+
+func (a TrustAnchors) String() string {
+	states := make(map[TrustAnchorState]int)
+	for _, anchor := range a.Anchors {
+		states[anchor.State]++
+	}
+	return fmt.Sprintf("TrustAnchors %s %v", a.Zone, states)
+}
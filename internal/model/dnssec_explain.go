@@ -0,0 +1,113 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DnssecExplanation describes the chain of trust walked to validate a response,
+// from the root down to the zones which signed it, and the step at which the validation failed if any.
+type DnssecExplanation struct {
+	Question   string                  `json:"question"`
+	Result     string                  `json:"result"`           // result of the validation of the response.
+	Failed     string                  `json:"failed,omitempty"` // step which failed, empty when the chain of trust holds.
+	Error      string                  `json:"error,omitempty"`
+	Zones      []DnssecZoneExplanation `json:"zones"`
+	Signatures []DnssecSigExplanation  `json:"signatures,omitempty"` // signatures of the answer and authority sections.
+	Denial     []string                `json:"denial,omitempty"`     // NSEC and NSEC3 records of a negative response.
+}
+
+// DnssecZoneExplanation describes the validation of the keys of a zone against its DS records in the parent zone.
+type DnssecZoneExplanation struct {
+	Zone       string                 `json:"zone"`
+	Status     string                 `json:"status"` // secure, insecure delegation, not a zone cut, or failed.
+	Error      string                 `json:"error,omitempty"`
+	Keys       []DnssecKeyExplanation `json:"keys,omitempty"`
+	DS         []DnssecDSExplanation  `json:"ds,omitempty"`         // DS records of the parent zone, or trust anchors of the root.
+	Signatures []DnssecSigExplanation `json:"signatures,omitempty"` // signatures of the DNSKEY and DS sets.
+	Denial     []string               `json:"denial,omitempty"`     // NSEC and NSEC3 records denying the DS.
+}
+
+type DnssecKeyExplanation struct {
+	KeyTag    uint16 `json:"keyTag"`
+	Algorithm string `json:"algorithm"`
+	Flags     uint16 `json:"flags"`
+}
+
+type DnssecDSExplanation struct {
+	KeyTag     uint16 `json:"keyTag"`
+	Algorithm  string `json:"algorithm"`
+	DigestType string `json:"digestType"`
+	Anchor     bool   `json:"anchor,omitempty"` // trust anchor of the root.
+	Matches    bool   `json:"matches"`          // the digest matches a key of the zone.
+}
+
+type DnssecSigExplanation struct {
+	RRset      string    `json:"rrset"`
+	KeyTag     uint16    `json:"keyTag"`
+	Algorithm  string    `json:"algorithm"`
+	Signer     string    `json:"signer"`
+	Inception  time.Time `json:"inception"`
+	Expiration time.Time `json:"expiration"`
+	Error      string    `json:"error,omitempty"` // empty when the signature is valid.
+}
+
+func (e DnssecExplanation) AsBytes() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// String renders the explanation as text, one step per line.
+func (e DnssecExplanation) String() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s: %s\n", e.Question, e.Result)
+
+	for _, z := range e.Zones {
+		fmt.Fprintf(&b, "\nzone %s: %s\n", z.Zone, z.Status)
+		for _, k := range z.Keys {
+			fmt.Fprintf(&b, "  DNSKEY keyTag=%d algorithm=%s flags=%d\n", k.KeyTag, k.Algorithm, k.Flags)
+		}
+		for _, ds := range z.DS {
+			kind := "DS"
+			if ds.Anchor {
+				kind = "ANCHOR"
+			}
+			fmt.Fprintf(&b, "  %s keyTag=%d algorithm=%s digest=%s matches=%v\n", kind, ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Matches)
+		}
+		writeSignatures(&b, z.Signatures)
+		for _, rr := range z.Denial {
+			fmt.Fprintf(&b, "  DENIAL %s\n", rr)
+		}
+		if z.Error != "" {
+			fmt.Fprintf(&b, "  ERROR %s\n", z.Error)
+		}
+	}
+
+	if len(e.Signatures) > 0 || len(e.Denial) > 0 {
+		fmt.Fprintf(&b, "\nresponse:\n")
+		writeSignatures(&b, e.Signatures)
+		for _, rr := range e.Denial {
+			fmt.Fprintf(&b, "  DENIAL %s\n", rr)
+		}
+	}
+
+	if e.Failed != "" {
+		fmt.Fprintf(&b, "\nfailed at %s: %s\n", e.Failed, e.Error)
+	}
+
+	return b.String()
+}
+
+func writeSignatures(b *strings.Builder, signatures []DnssecSigExplanation) {
+	for _, s := range signatures {
+		status := "valid"
+		if s.Error != "" {
+			status = s.Error
+		}
+		fmt.Fprintf(b, "  RRSIG %s keyTag=%d algorithm=%s signer=%s %s..%s: %s\n", s.RRset, s.KeyTag, s.Algorithm, s.Signer,
+			s.Inception.UTC().Format(time.RFC3339), s.Expiration.UTC().Format(time.RFC3339), status)
+	}
+}
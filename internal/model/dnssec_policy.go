@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// DnssecMode tells how the responses of a domain are validated.
+type DnssecMode string
+
+const (
+	DnssecEnforce          DnssecMode = "enforce"            // only secure responses are accepted.
+	DnssecValidateIfSigned DnssecMode = "validate-if-signed" // bogus responses are rejected, the unsigned ones of insecure zones are accepted.
+	DnssecSkip             DnssecMode = "skip"               // responses are not validated.
+)
+
+func ParseDnssecMode(s string) (DnssecMode, error) {
+	switch m := DnssecMode(s); m {
+	case DnssecEnforce, DnssecValidateIfSigned, DnssecSkip:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown dnssec mode: %s", s)
+}
+
+// DnssecPolicyRule sets the validation mode of a domain and of its sub-domains.
+// A rule skipping the validation until an expiration time is a negative trust anchor (RFC 7646).
+type DnssecPolicyRule struct {
+	Domain  string     `json:"domain"`
+	Mode    DnssecMode `json:"mode"`
+	Expires *time.Time `json:"expires,omitempty"` // the rule is permanent when nil.
+	Reason  string     `json:"reason,omitempty"`
+}
+
+func (r DnssecPolicyRule) IsExpired(now time.Time) bool {
+	return r.Expires != nil && !now.Before(*r.Expires)
+}
+
+// IsNegativeTrustAnchor tells whether the rule temporarily disables the validation.
+func (r DnssecPolicyRule) IsNegativeTrustAnchor() bool {
+	return r.Mode == DnssecSkip && r.Expires != nil
+}
+
+func (r DnssecPolicyRule) String() string {
+	kind := "policy"
+	if r.IsNegativeTrustAnchor() {
+		kind = "negative trust anchor"
+	}
+	s := fmt.Sprintf("%s %s mode=%s", kind, r.Domain, r.Mode)
+	if r.Expires != nil {
+		s += fmt.Sprintf(" expires=%s", r.Expires.Format(time.RFC3339))
+	}
+	if r.Reason != "" {
+		s += fmt.Sprintf(" reason=%q", r.Reason)
+	}
+	return s
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"golang-dns/internal/model"
+	"golang-dns/internal/service"
+	t "golang-dns/internal/transverse"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StartAdmin serves the cache administration API. It only listens on a loopback address.
+//
+//	GET    /cache?name=example.com&suffix=true  lists the cached entries, optionally filtered by name.
+//	DELETE /cache?name=example.com&suffix=true  evicts the entries of a name, or of a whole domain with suffix.
+//	POST   /cache/flush                          flushes every cache.
+//	GET    /ready                                tells if the server completed its start-up phase.
+//	GET    /metrics                              publishes the counters of the server, ex: DNSSEC validation results.
+//	GET    /dnssec/policy                        lists the validation modes of domains and the negative trust anchors.
+//	PUT    /dnssec/policy?name=example.com&mode=enforce|validate-if-signed|skip&reason=...  sets the mode of a domain.
+//	POST   /dnssec/nta?name=example.com&lifetime=24h&reason=...  skips the validation of a domain for a while (RFC 7646).
+//	DELETE /dnssec/policy?name=example.com      removes the mode or the negative trust anchor of a domain.
+func StartAdmin(addr string, policy service.DnssecPolicy, caches ...service.DnsCacheAdmin) error {
+
+	if err := verifyLoopback(addr); err != nil {
+		return err
+	}
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/cache", HandleCacheEntries(caches))
+	r.DELETE("/cache", HandleCacheEvict(caches))
+	r.POST("/cache/flush", HandleCacheFlush(caches))
+	r.GET("/ready", HandleReady())
+	r.GET("/metrics", gin.WrapH(expvar.Handler()))
+	r.GET("/dnssec/policy", HandlePolicyRules(policy))
+	r.PUT("/dnssec/policy", HandlePolicySet(policy, caches))
+	r.POST("/dnssec/nta", HandleNegativeTrustAnchor(policy, caches))
+	r.DELETE("/dnssec/policy", HandlePolicyDelete(policy, caches))
+
+	t.Logger().Printf("admin server started %s", addr)
+
+	return r.Run(addr)
+}
+
+func HandleCacheEntries(caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		name, suffix, err := nameParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		entries := make([]model.DnsCacheEntryInfo, 0)
+		for _, cache := range caches {
+			e, err := cache.Entries(name, suffix)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			entries = append(entries, e...)
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+func HandleCacheEvict(caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		name, suffix, err := nameParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a name, use /cache/flush to evict everything"})
+			return
+		}
+
+		count := 0
+		for _, cache := range caches {
+			n, err := cache.Evict(name, suffix)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			count += n
+		}
+
+		c.JSON(http.StatusOK, gin.H{"evicted": count})
+	}
+}
+
+func HandleCacheFlush(caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		for _, cache := range caches {
+			if err := cache.Flush(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"flushed": len(caches)})
+	}
+}
+
+func HandleReady() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !t.IsReady() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ready": true})
+	}
+}
+
+func HandlePolicyRules(policy service.DnssecPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, policy.Rules())
+	}
+}
+
+func HandlePolicySet(policy service.DnssecPolicy, caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		mode, err := model.ParseDnssecMode(c.Query("mode"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		setPolicyRule(c, policy, caches, model.DnssecPolicyRule{Domain: c.Query("name"), Mode: mode, Reason: c.Query("reason")})
+	}
+}
+
+func HandleNegativeTrustAnchor(policy service.DnssecPolicy, caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		lifetime, err := time.ParseDuration(c.DefaultQuery("lifetime", service.DefaultNtaLifetime.String()))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid lifetime parameter: %s", c.Query("lifetime"))})
+			return
+		}
+
+		rule, err := service.NewNegativeTrustAnchor(c.Query("name"), lifetime, c.Query("reason"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		setPolicyRule(c, policy, caches, rule)
+	}
+}
+
+func HandlePolicyDelete(policy service.DnssecPolicy, caches []service.DnsCacheAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		name := c.Query("name")
+		if !policy.Delete(name) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no dnssec policy for %s", name)})
+			return
+		}
+
+		evictDomain(c, caches, name, gin.H{"deleted": name})
+	}
+}
+
+func setPolicyRule(c *gin.Context, policy service.DnssecPolicy, caches []service.DnsCacheAdmin, rule model.DnssecPolicyRule) {
+
+	if err := policy.Set(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	evictDomain(c, caches, rule.Domain, rule)
+}
+
+// evictDomain evicts the cached answers of a domain, so that a change of its policy applies at once.
+func evictDomain(c *gin.Context, caches []service.DnsCacheAdmin, name string, obj interface{}) {
+
+	for _, cache := range caches {
+		if _, err := cache.Evict(name, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, obj)
+}
+
+func nameParams(c *gin.Context) (string, bool, error) {
+
+	name := c.Query("name")
+	if _, valid := dns.IsDomainName(name); name != "" && !valid {
+		return name, false, fmt.Errorf("must provide a valid domain name")
+	}
+
+	suffix, err := strconv.ParseBool(c.DefaultQuery("suffix", "false"))
+	if err != nil {
+		return name, false, fmt.Errorf("invalid suffix parameter: %s", c.Query("suffix"))
+	}
+
+	return name, suffix, nil
+}
+
+func verifyLoopback(addr string) error {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address %s: %s", addr, err.Error())
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("admin server must listen on a loopback address: %s", addr)
+	}
+
+	return nil
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"errors"
 	"github.com/miekg/dns"
 	"golang-dns/internal/model"
 	"golang-dns/internal/service"
 	t "golang-dns/internal/transverse"
+	"net"
 )
 
 type DnsOverHttpsHandler struct {
@@ -20,15 +22,59 @@ func NewDnsOverHttpsHandler(resolver service.DnsResolverProxy) DnsOverHttpsHandl
 // ServeDNS implements the dns.Handler interface
 func (h DnsOverHttpsHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 
-	rm, err := h.resolver.Proxy(model.NewDnsMsg(req))
+	m := model.NewDnsMsg(req).WithClient(ClientIP(w.RemoteAddr()))
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); tcp {
+		m = m.WithStream()
+	}
+
+	rm, err := h.resolver.Proxy(m)
+
+	// the query exceeds the rate limits of the client.
+	if errors.Is(err, service.ErrRateLimitDrop) {
+		return
+	}
 
 	if err != nil {
 		t.LoggerError().Printf("error in resolver: %s", err.Error())
-		h.WriteMsg(w, req)
-		return
 	}
 
-	h.WriteMsg(w, rm.GetMsg())
+	h.WriteMsg(w, NewResponse(req, rm, err))
+}
+
+// ClientIP returns the address of the client, nil when unknown.
+func ClientIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// NewResponse builds the response sent to the client: the AD bit is only set on secure answers
+// to the clients which understand it, that is which sent the DO or the AD bit (RFC 6840 §5.8),
+// failures are answered with SERVFAIL along with the extended DNS error explaining a failed validation.
+func NewResponse(req *dns.Msg, rm model.DnsMsg, err error) *dns.Msg {
+
+	result := rm.GetDnssecResult()
+
+	if err != nil {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		if o := req.IsEdns0(); o != nil && result.Ede != nil {
+			m.SetEdns0(o.UDPSize(), o.Do())
+			m.IsEdns0().Option = append(m.IsEdns0().Option, result.Ede)
+		}
+		return m
+	}
+
+	o := req.IsEdns0()
+	aware := req.AuthenticatedData || (o != nil && o.Do())
+
+	m := rm.GetMsg()
+	m.AuthenticatedData = aware && result.Status == model.DnssecSecure
+	return m
 }
 
 func (h DnsOverHttpsHandler) WriteMsg(w dns.ResponseWriter, m *dns.Msg) {
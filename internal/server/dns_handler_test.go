@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"net"
+	"testing"
+)
+
+func TestNewResponse(t *testing.T) {
+
+	secure := model.DnssecResult{Status: model.DnssecSecure}
+	bogus := model.DnssecResult{Status: model.DnssecBogus, Ede: &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeSignatureExpired}}
+
+	tests := []struct {
+		name   string
+		do     bool
+		ad     bool
+		result model.DnssecResult
+		err    error
+		rcode  int
+		expect bool // AD bit of the response
+	}{
+		{"secure with DO", true, false, secure, nil, dns.RcodeSuccess, true},
+		{"secure with AD", false, true, secure, nil, dns.RcodeSuccess, true},
+		{"secure without DO nor AD", false, false, secure, nil, dns.RcodeSuccess, false},
+		{"insecure", true, true, model.DnssecResult{Status: model.DnssecInsecure}, nil, dns.RcodeSuccess, false},
+		{"bogus", true, true, bogus, errors.New("bogus"), dns.RcodeServerFailure, false},
+	}
+
+	for _, tt := range tests {
+
+		req := h.Msg("example.com", dns.TypeA, dns.ClassINET)
+		req.IsEdns0().SetDo(tt.do)
+		req.AuthenticatedData = tt.ad
+
+		in := new(dns.Msg)
+		in.SetReply(req)
+		in.AuthenticatedData = true // as set by the upstream resolver
+
+		m := NewResponse(req, model.NewDnsMsg(in).WithDnssecResult(tt.result), tt.err)
+
+		if m.Rcode != tt.rcode || m.AuthenticatedData != tt.expect {
+			t.Fatalf("%s: got rcode=%s ad=%v", tt.name, dns.RcodeToString[m.Rcode], m.AuthenticatedData)
+		}
+
+		if tt.result.Ede != nil {
+			o := m.IsEdns0()
+			if o == nil || len(o.Option) != 1 || o.Option[0].(*dns.EDNS0_EDE).InfoCode != tt.result.Ede.InfoCode {
+				t.Fatalf("%s: extended DNS error expected", tt.name)
+			}
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestClientIP(t *testing.T) {
+
+	tests := []struct {
+		addr     net.Addr
+		expected net.IP
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}, net.IPv4(192, 0, 2, 1)},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53000}, net.ParseIP("2001:db8::1")},
+		{&net.UnixAddr{Name: "/tmp/dns.sock", Net: "unix"}, nil},
+	}
+
+	for _, tt := range tests {
+		if ip := ClientIP(tt.addr); !ip.Equal(tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.addr, tt.expected, ip)
+		}
+	}
+
+	t.Logf("Success !")
+}
@@ -1,11 +1,13 @@
 package server
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/miekg/dns"
 	"golang-dns/internal/model"
 	"golang-dns/internal/service"
 	"io/ioutil"
+	"net"
 	"net/http"
 )
 
@@ -33,9 +35,14 @@ func HandleDnsQuery(resolver service.DnsResolverProxy) gin.HandlerFunc {
 			return
 		}
 
-		r, err := resolver.Proxy(model.NewDnsMsg(m))
+		// the address of the peer, the forwarded headers may be forged to escape the rate limits.
+		r, err := resolver.Proxy(model.NewDnsMsg(m).WithClient(net.ParseIP(c.RemoteIP())).WithStream())
+		if errors.Is(err, service.ErrRateLimitDrop) {
+			c.Status(http.StatusTooManyRequests)
+			return
+		}
 
-		output, err := r.GetMsg().Pack()
+		output, err := NewResponse(m, r, err).Pack()
 		if err != nil {
 			c.Data(http.StatusBadRequest, "application/dns-message", body)
 			return
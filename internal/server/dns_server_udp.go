@@ -29,3 +29,27 @@ func RunLocalUDPServer(network, addr string, resolver service.DnsResolverProxy)
 
 	return err
 }
+
+// RunLocalTCPServer answers the queries over TCP, ex: the retries of the clients which received a truncated response.
+func RunLocalTCPServer(network, addr string, resolver service.DnsResolverProxy) error {
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	server := &dns.Server{
+		Listener: l,
+		Handler:  NewDnsOverHttpsHandler(resolver),
+		NotifyStartedFunc: func() {
+			t.Logger().Printf("server started %s%s", network, addr)
+		},
+	}
+
+	err = server.ActivateAndServe()
+	if err = l.Close(); err != nil {
+		t.LoggerError().Printf("error closing Listener: %s", err.Error())
+	}
+
+	return err
+}
@@ -1,46 +1,335 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger/v3"
+	"golang-dns/internal/model"
 	"golang-dns/internal/transverse"
 	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	path       = "/tmp/badger"
-	defaultTTL = 24 * time.Hour
+	DefaultBadgerPath           = "/tmp/badger"
+	DefaultBadgerMaxSize        = 256 << 20 // 256 MiB
+	DefaultBadgerGCInterval     = 10 * time.Minute
+	DefaultBadgerGCDiscardRatio = 0.5
+
+	valueLogFileSize = 64 << 20 // small value log files so that the GC can reclaim them.
+
+	// expired responses are kept a while, so they can be resolved again when the server restarts.
+	badgerRetention = 24 * time.Hour
+
+	backendBadger = "badger"
 )
 
+// BadgerConfig bounds the size of the database and tells how to recover from a corrupted one.
+type BadgerConfig struct {
+	Path           string
+	MaxSize        int64         // maximum size in bytes of the database, unlimited when zero.
+	GCInterval     time.Duration // interval between two maintenances: value log GC and size enforcement.
+	GCDiscardRatio float64       // rewrite value log files having at least this ratio of discardable data.
+	Recover        bool          // move a corrupted database aside and start with an empty one, other errors are returned.
+}
+
+func DefaultBadgerConfig() BadgerConfig {
+	return BadgerConfig{
+		Path:           DefaultBadgerPath,
+		MaxSize:        DefaultBadgerMaxSize,
+		GCInterval:     DefaultBadgerGCInterval,
+		GCDiscardRatio: DefaultBadgerGCDiscardRatio,
+		Recover:        true,
+	}
+}
+
 type Badger struct {
-	db *badger.DB
+	db     *badger.DB
+	conf   BadgerConfig
+	done   chan struct{}
+	closed *sync.Once
+}
+
+// badgerCorruptions are the errors of a database whose files are corrupted, ex: by a crash while writing them.
+// The other errors, ex: a full or read-only disk, a missing permission, a lock held by another process, are not recovered.
+var badgerCorruptions = []string{
+	"bad magic",
+	"checksum",
+	"corrupt",
+	"invalid datakey id",
+	"unexpected eof",
+	"truncate",
+	"file does not exist for table",
+	"manifest removes non-existing table",
 }
 
 func NewBadger() Badger {
+	b, err := NewBadgerWithConfig(DefaultBadgerConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+func NewBadgerWithConfig(conf BadgerConfig) (Badger, error) {
 	var b Badger
 	defer transverse.Logger().Printf("%s initialized", &b)
 
-	db, err := badger.Open(badger.DefaultOptions(path))
+	b.conf = conf
+
+	db, err := b.open()
 	if err != nil {
-		log.Fatal(err)
+		return b, err
 	}
 
 	b.db = db
+	b.done = make(chan struct{})
+	b.closed = new(sync.Once)
 
-	return b
+	if err := b.migrateKeys(); err != nil {
+		transverse.LoggerError().Printf("unable to migrate keys: %s", err.Error())
+	}
+
+	if conf.GCInterval > 0 {
+		b.ContinuouslyMaintain()
+	}
+
+	return b, nil
+}
+
+// open opens the database, in recovery mode a corrupted database is moved aside and replaced by an empty one.
+func (b Badger) open() (*badger.DB, error) {
+
+	opts := badger.DefaultOptions(b.conf.Path).WithValueLogFileSize(valueLogFileSize)
+
+	db, err := badger.Open(opts)
+	if err == nil {
+		return db, nil
+	}
+
+	if !b.conf.Recover || !isBadgerCorruption(err) {
+		return nil, fmt.Errorf("unable to open badger %s: %s", b.conf.Path, err.Error())
+	}
+
+	aside := fmt.Sprintf("%s.corrupted-%d", b.conf.Path, time.Now().Unix())
+	transverse.LoggerError().Printf("unable to open badger %s: %s: moving it to %s", b.conf.Path, err.Error(), aside)
+
+	if err := os.Rename(b.conf.Path, aside); err != nil {
+		return nil, fmt.Errorf("unable to move corrupted badger aside: %s", err.Error())
+	}
+
+	return badger.Open(opts)
+}
+
+func isBadgerCorruption(err error) bool {
+	if errors.Is(err, badger.ErrTruncateNeeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, v := range badgerCorruptions {
+		if strings.Contains(msg, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContinuouslyMaintain periodically enforces the maximum size and runs the value log GC, until Close is called.
+func (b Badger) ContinuouslyMaintain() {
+	go func() {
+		ticker := time.NewTicker(b.conf.GCInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.Maintain()
+			case <-b.done:
+				return
+			}
+		}
+	}()
+}
+
+func (b Badger) Maintain() {
+
+	if err := b.prune(); err != nil {
+		transverse.LoggerError().Printf("unable to prune badger: %s", err.Error())
+	}
+
+	for {
+		err := b.db.RunValueLogGC(b.conf.GCDiscardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			break
+		}
+		if err != nil {
+			transverse.LoggerError().Printf("unable to run badger value log GC: %s", err.Error())
+			break
+		}
+	}
+}
+
+// prune deletes the least recently stored entries until the database fits in its maximum size.
+// Entries are stored again each time they are resolved, the oldest ones are the least recently used.
+func (b Badger) prune() error {
+
+	lsm, vlog := b.db.Size()
+	excess := lsm + vlog - b.conf.MaxSize
+	if b.conf.MaxSize <= 0 || excess <= 0 {
+		return nil
+	}
+
+	// free a bit more than required, not to prune again at the next maintenance.
+	count, err := b.deleteOldest(excess + b.conf.MaxSize/10)
+	if err != nil {
+		return err
+	}
+
+	transverse.Logger().Printf("badger pruned: %d entries deleted, size was %d bytes", count, lsm+vlog)
+
+	return nil
+}
+
+// deleteOldest deletes the oldest entries until at least target bytes are freed.
+func (b Badger) deleteOldest(target int64) (int, error) {
+
+	type stored struct {
+		key     []byte
+		version uint64
+		size    int64
+	}
+
+	items := make([]stored, 0)
+	err := b.db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			items = append(items, stored{key: item.KeyCopy(nil), version: item.Version(), size: item.EstimatedSize()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].version < items[j].version
+	})
+
+	wb := b.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	freed, count := int64(0), 0
+	for _, item := range items {
+		if freed >= target {
+			break
+		}
+		if err := wb.Delete(item.key); err != nil {
+			return count, err
+		}
+		freed += item.size
+		count++
+	}
+
+	return count, wb.Flush()
+}
+
+// migrateKeys rewrites the entries stored under a legacy cache key with the current key format.
+func (b Badger) migrateKeys() error {
+
+	legacy := make([]model.DnsCacheKey, 0)
+	err := b.IterateOverKeys(func(key []byte) {
+		if k := model.DnsCacheKey(key); k.IsLegacy() {
+			legacy = append(legacy, k)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range legacy {
+		err = b.db.Update(func(txn *badger.Txn) error {
+
+			item, err := txn.Get([]byte(k))
+			if err != nil {
+				return err
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			e := badger.NewEntry([]byte(k.Migrate()), data)
+			if expiresAt := item.ExpiresAt(); expiresAt > 0 {
+				e = e.WithTTL(time.Until(time.Unix(int64(expiresAt), 0)))
+			}
+
+			if err := txn.SetEntry(e); err != nil {
+				return err
+			}
+
+			return txn.Delete([]byte(k))
+		})
+		if err != nil {
+			return fmt.Errorf("key %s: %s", k, err.Error())
+		}
+	}
+
+	if len(legacy) > 0 {
+		transverse.Logger().Printf("%d legacy keys migrated", len(legacy))
+	}
+
+	return nil
 }
 
-func (b Badger) StoreEntry(key, data []byte) error {
+func (b Badger) StoreEntry(key, data []byte, ttl time.Duration) error {
 
 	err := b.db.Update(func(txn *badger.Txn) error {
-		e := badger.NewEntry(key, data).WithTTL(defaultTTL)
+		e := badger.NewEntry(key, data).WithTTL(ttl)
 		return txn.SetEntry(e)
 	})
 
 	return err
 }
 
+// Load reads a value stored without expiration by Save.
+func (b Badger) Load(key string) ([]byte, bool, error) {
+
+	var data []byte
+
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+
+	return data, err == nil, err
+}
+
+// Save stores a value without expiration.
+func (b Badger) Save(key string, data []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), data)
+	})
+}
+
 func (b Badger) IterateOverKeys(fn func([]byte)) error {
 
 	transverse.Logger().Println("Iterating over keys")
@@ -63,10 +352,96 @@ func (b Badger) IterateOverKeys(fn func([]byte)) error {
 	return err
 }
 
+// Get implements DnsCacheStorage
+func (b Badger) Get(key string) (model.DnsCacheEntry, bool) {
+
+	var entry model.DnsCacheEntry
+
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		entry = model.NewDnsCacheEntryFromBytes(data)
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		transverse.LoggerError().Printf("unable to read badger entry %s: %s", key, err.Error())
+	}
+
+	return entry, err == nil
+}
+
+// Set implements DnsCacheStorage
+func (b Badger) Set(key string, entry model.DnsCacheEntry) error {
+	data, err := entry.AsBytes()
+	if err != nil {
+		return fmt.Errorf("error encoding badger entry: %s", err.Error())
+	}
+	return b.StoreEntry([]byte(key), data, entry.Remaining()+badgerRetention)
+}
+
+// Delete implements DnsCacheStorage
+func (b Badger) Delete(key string) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Iterate implements DnsCacheStorage
+func (b Badger) Iterate(fn func(string, model.DnsCacheEntry) error) error {
+
+	err := b.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+
+			item := it.Item()
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(string(item.Key()), model.NewDnsCacheEntryFromBytes(data)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Clear implements DnsCacheStorage
+func (b Badger) Clear() error {
+	return b.db.DropAll()
+}
+
+// Name implements DnsCacheStorage
+func (_ Badger) Name() string {
+	return backendBadger
+}
+
+// Close stops the maintenance and closes the database, only the first call has an effect.
 func (b Badger) Close() {
-	_ = b.db.Close()
+	if b.closed == nil {
+		return
+	}
+	b.closed.Do(func() {
+		close(b.done)
+		_ = b.db.Close()
+	})
 }
 
 func (b Badger) String() string {
-	return fmt.Sprintf("Badger %s", path)
+	return fmt.Sprintf("Badger %s maxSize=%d", b.conf.Path, b.conf.MaxSize)
 }
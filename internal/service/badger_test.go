@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func NewTestBadger(t *testing.T, dir string) Badger {
+	conf := DefaultBadgerConfig()
+	conf.Path = dir
+	conf.GCInterval = 0
+	b, err := NewBadgerWithConfig(conf)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return b
+}
+
+func storeTestEntry(t *testing.T, b Badger, name string, ttl uint32) {
+	m, err := NewDnsResolverStub(ttl).Proxy(model.NewDnsMsg(h.Msg(name, dns.TypeA, dns.ClassINET)))
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	entry, _ := model.NewDnsCacheEntry(m, time.Duration(ttl)*time.Second)
+	if err := b.Set(model.NewDnsCacheKey(m), entry); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+}
+
+func TestBadgerDeleteOldest(t *testing.T) {
+
+	b := NewTestBadger(t, t.TempDir())
+	defer b.Close()
+
+	for i := 0; i < 10; i++ {
+		storeTestEntry(t, b, fmt.Sprintf("host%d.example.com", i), 300)
+	}
+
+	count, err := b.deleteOldest(1)
+	if err != nil || count != 1 {
+		t.Fatalf("expect 1 deleted entry, got %d %v", count, err)
+	}
+
+	admin := NewDnsCacheAdmin(b)
+
+	entries, _ := admin.Entries("host0.example.com", false)
+	if len(entries) != 0 {
+		t.Fatalf("the oldest entry must be deleted: %v", entries)
+	}
+
+	entries, _ = admin.Entries("example.com", true)
+	if len(entries) != 9 {
+		t.Fatalf("expect 9 entries, got %d", len(entries))
+	}
+
+	t.Logf("Success !")
+}
+
+func TestBadgerRecover(t *testing.T) {
+
+	corrupted := func() string {
+		dir := filepath.Join(t.TempDir(), "badger")
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		if err := os.WriteFile(filepath.Join(dir, "MANIFEST"), []byte("corrupted"), os.ModePerm); err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		return dir
+	}
+
+	dir := corrupted()
+	b := NewTestBadger(t, dir)
+	defer b.Close()
+
+	matches, _ := filepath.Glob(dir + ".corrupted-*")
+	if len(matches) != 1 {
+		t.Fatalf("corrupted database must be moved aside")
+	}
+
+	storeTestEntry(t, b, "example.com", 300)
+
+	// a database used by another process is not corrupted.
+	conf := DefaultBadgerConfig()
+	conf.Path, conf.GCInterval = dir, 0
+	if _, err := NewBadgerWithConfig(conf); err == nil {
+		t.Fatalf("expect a locked database not to be opened")
+	}
+	if matches, _ := filepath.Glob(dir + ".corrupted-*"); len(matches) != 1 {
+		t.Fatalf("a locked database must not be moved aside")
+	}
+
+	// the trust anchors are never recovered.
+	conf = DefaultAnchorsBadgerConfig()
+	conf.Path, conf.GCInterval = corrupted(), 0
+	if _, err := NewBadgerWithConfig(conf); err == nil {
+		t.Fatalf("expect a corrupted trust anchors database not to be recovered")
+	}
+
+	// closing twice has no effect.
+	b.Close()
+
+	t.Logf("Success !")
+}
+
+func TestDnsCachePreload(t *testing.T) {
+
+	b := NewTestBadger(t, t.TempDir())
+	defer b.Close()
+
+	storeTestEntry(t, b, "valid.example.com", 300)
+	storeTestEntry(t, b, "expired.example.com", 0)
+
+	stub := NewDnsResolverStub(300)
+	cache := NewRistretto(DefaultRistrettoConfig())
+
+	p := NewDnsCachePreload(stub, cache, b, DefaultDnsCachePreloadConfig())
+	if err := p.Preload(context.Background()); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	// the expired entry has been resolved again, the valid one has been loaded from the database.
+	if stub.Queries() != 1 {
+		t.Fatalf("expect 1 upstream query, got %d", stub.Queries())
+	}
+
+	entries, _ := NewDnsCacheAdmin(cache).Entries("valid.example.com", false)
+	if len(entries) != 1 {
+		t.Fatalf("valid entry must be loaded in cache")
+	}
+
+	t.Logf("Success !")
+}
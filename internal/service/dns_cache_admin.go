@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+)
+
+// DnsCacheAdmin gives access to the content of a cache for administration purposes.
+// An empty name selects every entry, suffix selects the entries of the sub-domains of name as well.
+type DnsCacheAdmin interface {
+	Entries(name string, suffix bool) ([]model.DnsCacheEntryInfo, error)
+	Evict(name string, suffix bool) (int, error)
+	Flush() error
+}
+
+type DnsCacheStorageAdmin struct {
+	storages []DnsCacheStorage
+}
+
+func NewDnsCacheAdmin(storages ...DnsCacheStorage) DnsCacheAdmin {
+	return DnsCacheStorageAdmin{storages: storages}
+}
+
+func (a DnsCacheStorageAdmin) Entries(name string, suffix bool) ([]model.DnsCacheEntryInfo, error) {
+
+	entries := make([]model.DnsCacheEntryInfo, 0)
+
+	for _, storage := range a.storages {
+		err := storage.Iterate(func(key string, entry model.DnsCacheEntry) error {
+
+			if !model.DnsCacheKey(key).Matches(name, suffix) || entry.IsExpired() {
+				return nil
+			}
+
+			m, err := entry.Value()
+			if err != nil {
+				transverse.LoggerError().Printf("found corrupted %s entry %s: %s", storage.Name(), key, err.Error())
+				return nil
+			}
+
+			entries = append(entries, model.NewDnsCacheEntryInfo(storage.Name(), key, entry.Remaining(), m))
+			return nil
+		})
+		if err != nil {
+			return entries, fmt.Errorf("%s: %s", storage.Name(), err.Error())
+		}
+	}
+
+	return entries, nil
+}
+
+func (a DnsCacheStorageAdmin) Evict(name string, suffix bool) (int, error) {
+
+	count := 0
+
+	for _, storage := range a.storages {
+
+		keys := make([]string, 0)
+		err := storage.Iterate(func(key string, _ model.DnsCacheEntry) error {
+			if model.DnsCacheKey(key).Matches(name, suffix) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, fmt.Errorf("%s: %s", storage.Name(), err.Error())
+		}
+
+		for _, key := range keys {
+			if err := storage.Delete(key); err != nil {
+				return count, fmt.Errorf("%s: %s", storage.Name(), err.Error())
+			}
+		}
+
+		transverse.Logger().Printf("%d entries evicted from %s: %s", len(keys), storage.Name(), name)
+		count += len(keys)
+	}
+
+	return count, nil
+}
+
+func (a DnsCacheStorageAdmin) Flush() error {
+	for _, storage := range a.storages {
+		if err := storage.Clear(); err != nil {
+			return fmt.Errorf("%s: %s", storage.Name(), err.Error())
+		}
+		transverse.Logger().Printf("%s flushed", storage.Name())
+	}
+	return nil
+}
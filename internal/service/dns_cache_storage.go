@@ -0,0 +1,16 @@
+package service
+
+import (
+	"golang-dns/internal/model"
+)
+
+// DnsCacheStorage stores the cached responses by cache key.
+// Entries carry their own expiry, a storage may keep expired entries.
+type DnsCacheStorage interface {
+	Get(key string) (model.DnsCacheEntry, bool)
+	Set(key string, entry model.DnsCacheEntry) error
+	Delete(key string) error
+	Iterate(fn func(string, model.DnsCacheEntry) error) error
+	Clear() error
+	Name() string
+}
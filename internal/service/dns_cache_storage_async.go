@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+)
+
+const (
+	// writes waiting for a slow storage, the ones beyond are dropped rather than delaying the queries.
+	asyncStorageQueueSize = 1024
+)
+
+// DnsCacheStorageAsync writes to a slow storage in the background, ex: the disk tier of the cache,
+// so that the queries never wait for a disk write. Reads are served by the storage right away,
+// deletions are queued after the pending writes and waited for.
+type DnsCacheStorageAsync struct {
+	DnsCacheStorage
+	w chan dnsCacheStorageWrite
+}
+
+type dnsCacheStorageWrite struct {
+	apply func() error
+	done  chan error // nil when nobody waits for the write.
+}
+
+func NewDnsCacheStorageAsync(storage DnsCacheStorage) DnsCacheStorageAsync {
+	var s DnsCacheStorageAsync
+	defer transverse.Logger().Printf("%s initialized", &s)
+
+	s.DnsCacheStorage = storage
+	s.w = make(chan dnsCacheStorageWrite, asyncStorageQueueSize)
+
+	s.ContinuouslyWrite()
+
+	return s
+}
+
+func (s DnsCacheStorageAsync) ContinuouslyWrite() {
+	go func() {
+		for w := range s.w {
+			err := w.apply()
+			if w.done != nil {
+				w.done <- err
+				continue
+			}
+			if err != nil {
+				transverse.LoggerError().Printf("unable to store %s entry: %s", s.Name(), err.Error())
+			}
+		}
+	}()
+}
+
+// Set implements DnsCacheStorage, the entry is stored in the background, dropped when too many writes are pending.
+func (s DnsCacheStorageAsync) Set(key string, entry model.DnsCacheEntry) error {
+	select {
+	case s.w <- dnsCacheStorageWrite{apply: func() error { return s.DnsCacheStorage.Set(key, entry) }}:
+		return nil
+	default:
+		return fmt.Errorf("too many pending writes: entry %s dropped", key)
+	}
+}
+
+// Delete implements DnsCacheStorage
+func (s DnsCacheStorageAsync) Delete(key string) error {
+	return s.wait(func() error { return s.DnsCacheStorage.Delete(key) })
+}
+
+// Clear implements DnsCacheStorage
+func (s DnsCacheStorageAsync) Clear() error {
+	return s.wait(s.DnsCacheStorage.Clear)
+}
+
+// wait queues the write after the pending ones, so that an entry stored before is not written back, and returns its result.
+func (s DnsCacheStorageAsync) wait(apply func() error) error {
+	done := make(chan error, 1)
+	s.w <- dnsCacheStorageWrite{apply: apply, done: done}
+	return <-done
+}
+
+func (s DnsCacheStorageAsync) String() string {
+	return fmt.Sprintf("DnsCacheStorageAsync %s queue=%d", s.Name(), cap(s.w))
+}
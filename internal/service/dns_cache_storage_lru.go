@@ -0,0 +1,142 @@
+package service
+
+import (
+	"container/list"
+	"fmt"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"sync"
+)
+
+const (
+	backendLru = "lru"
+)
+
+// Lru is an in-memory DnsCacheStorage bounded by the size of its entries,
+// evicting the least recently used ones first.
+type Lru struct {
+	mu      *sync.Mutex
+	items   map[string]*list.Element
+	order   *list.List // front is the most recently used.
+	cost    *int64
+	maxCost int64
+}
+
+type lruItem struct {
+	key   string
+	entry model.DnsCacheEntry
+}
+
+func NewLru(maxCost int64) Lru {
+	var l Lru
+	defer transverse.Logger().Printf("%s initialized", &l)
+	l.mu = new(sync.Mutex)
+	l.items = make(map[string]*list.Element)
+	l.order = list.New()
+	l.cost = new(int64)
+	l.maxCost = maxCost
+	return l
+}
+
+// Get implements DnsCacheStorage
+func (l Lru) Get(key string) (model.DnsCacheEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, found := l.items[key]
+	if !found {
+		return model.DnsCacheEntry{}, false
+	}
+
+	item := e.Value.(lruItem)
+	if item.entry.IsExpired() {
+		l.remove(e)
+		return model.DnsCacheEntry{}, false
+	}
+
+	l.order.MoveToFront(e)
+	return item.entry, true
+}
+
+// Set implements DnsCacheStorage
+func (l Lru) Set(key string, entry model.DnsCacheEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, found := l.items[key]; found {
+		l.remove(e)
+	}
+
+	if entry.IsExpired() || entry.Cost() > l.maxCost {
+		return nil
+	}
+
+	l.items[key] = l.order.PushFront(lruItem{key: key, entry: entry})
+	*l.cost += entry.Cost()
+
+	for *l.cost > l.maxCost {
+		l.remove(l.order.Back())
+	}
+
+	return nil
+}
+
+// Delete implements DnsCacheStorage
+func (l Lru) Delete(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, found := l.items[key]; found {
+		l.remove(e)
+	}
+	return nil
+}
+
+// Iterate implements DnsCacheStorage
+func (l Lru) Iterate(fn func(string, model.DnsCacheEntry) error) error {
+
+	// work on a snapshot, fn is allowed to modify the storage.
+	l.mu.Lock()
+	items := make([]lruItem, 0, len(l.items))
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		items = append(items, e.Value.(lruItem))
+	}
+	l.mu.Unlock()
+
+	for _, item := range items {
+		if err := fn(item.key, item.entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Clear implements DnsCacheStorage
+func (l Lru) Clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.items {
+		delete(l.items, key)
+	}
+	l.order.Init()
+	*l.cost = 0
+	return nil
+}
+
+// Name implements DnsCacheStorage
+func (_ Lru) Name() string {
+	return backendLru
+}
+
+// remove must be called with the lock held.
+func (l Lru) remove(e *list.Element) {
+	item := l.order.Remove(e).(lruItem)
+	delete(l.items, item.key)
+	*l.cost -= item.entry.Cost()
+}
+
+func (l Lru) String() string {
+	return fmt.Sprintf("Lru maxCost=%d", l.maxCost)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"sync"
+)
+
+const (
+	DefaultCacheMaxCost = 32 << 20 // 32 MiB
+
+	averageEntryCost = 512 // average size in bytes of a packed dns response.
+
+	backendRistretto = "ristretto"
+)
+
+// RistrettoConfig bounds the memory used by the cached entries.
+type RistrettoConfig struct {
+	MaxCost     int64 // maximum size in bytes of the cached responses.
+	NumCounters int64 // number of keys to track frequency of, derived from MaxCost when zero.
+}
+
+func DefaultRistrettoConfig() RistrettoConfig {
+	return RistrettoConfig{
+		MaxCost: DefaultCacheMaxCost,
+	}
+}
+
+func (c RistrettoConfig) numCounters() int64 {
+	if c.NumCounters > 0 {
+		return c.NumCounters
+	}
+	// ristretto recommends tracking 10x the number of items expected when the cache is full.
+	return 10 * c.MaxCost / averageEntryCost
+}
+
+// Ristretto is an in-memory DnsCacheStorage, admitting and evicting entries by access frequency.
+type Ristretto struct {
+	cache *ristretto.Cache
+	conf  RistrettoConfig
+	keys  *sync.Map // ristretto only knows the hash of the keys: hash -> key
+}
+
+func NewRistretto(conf RistrettoConfig) Ristretto {
+
+	var r Ristretto
+
+	defer transverse.Logger().Printf("%s initialized", &r)
+
+	keys := new(sync.Map)
+	unindex := func(item *ristretto.Item) {
+		keys.Delete(item.Key)
+	}
+
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: conf.numCounters(), // number of keys to track frequency of.
+		MaxCost:     conf.MaxCost,       // maximum cost of cache, in bytes.
+		BufferItems: 64,                 // number of keys per Get buffer.
+		OnEvict:     unindex,
+		OnReject:    unindex,
+	})
+	if err != nil {
+		transverse.Logger().Fatal(err)
+	}
+
+	r.cache = cache
+	r.conf = conf
+	r.keys = keys
+
+	return r
+}
+
+// Get implements DnsCacheStorage
+func (r Ristretto) Get(key string) (model.DnsCacheEntry, bool) {
+	value, found := r.cache.Get(key)
+	if !found {
+		return model.DnsCacheEntry{}, false
+	}
+	return value.(model.DnsCacheEntry), true
+}
+
+// Set implements DnsCacheStorage
+func (r Ristretto) Set(key string, entry model.DnsCacheEntry) error {
+	ttl := entry.Remaining()
+	if ttl <= 0 {
+		return nil // ristretto would keep an entry without TTL forever.
+	}
+	hash, _ := z.KeyToHash(key)
+	r.keys.Store(hash, key)
+	r.cache.SetWithTTL(key, entry, entry.Cost(), ttl)
+	r.cache.Wait()
+	return nil
+}
+
+// Delete implements DnsCacheStorage
+func (r Ristretto) Delete(key string) error {
+	hash, _ := z.KeyToHash(key)
+	r.cache.Del(key)
+	r.keys.Delete(hash)
+	r.cache.Wait()
+	return nil
+}
+
+// Iterate implements DnsCacheStorage
+func (r Ristretto) Iterate(fn func(string, model.DnsCacheEntry) error) error {
+
+	var err error
+
+	r.keys.Range(func(_, k interface{}) bool {
+		key := k.(string)
+		if entry, found := r.Get(key); found {
+			err = fn(key, entry)
+		}
+		return err == nil
+	})
+
+	return err
+}
+
+// Clear implements DnsCacheStorage
+func (r Ristretto) Clear() error {
+	r.cache.Clear()
+	r.keys.Range(func(hash, _ interface{}) bool {
+		r.keys.Delete(hash)
+		return true
+	})
+	return nil
+}
+
+// Name implements DnsCacheStorage
+func (_ Ristretto) Name() string {
+	return backendRistretto
+}
+
+func (r Ristretto) String() string {
+	return fmt.Sprintf("Ristretto maxCost=%d numCounters=%d", r.conf.MaxCost, r.conf.numCounters())
+}
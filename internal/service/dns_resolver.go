@@ -25,7 +25,9 @@ func NewDnsResolverRestyImpl(client HardenedResty, url string) DnsResolverProxy
 }
 
 func (rsv DnsResolverRestyImpl) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
-	in, err := rsv.packPostUnpack(rm.WithDNSSEC().GetMsg())
+	// the request belongs to the client, work on a copy of it.
+	m := model.NewDnsMsg(rm.GetMsg().Copy())
+	in, err := rsv.packPostUnpack(m.WithDNSSEC().GetMsg())
 	return model.NewDnsMsg(in), err
 }
 
@@ -52,10 +54,11 @@ func (rsv DnsResolverRestyImpl) packPostUnpack(m *dns.Msg) (*dns.Msg, error) {
 	}
 
 	// this client does not handler recursive queries.
-	// reject the answer if recursion is not available on the server side.
+	// reject the answer if recursion is not available on the server side,
+	// or if the server did not check an answer the client expects to be checked.
 	if !in.MsgHdr.RecursionAvailable ||
 		in.MsgHdr.Truncated ||
-		in.MsgHdr.CheckingDisabled {
+		(in.MsgHdr.CheckingDisabled && !m.CheckingDisabled) {
 		return in, fmt.Errorf("not acceptable response received %+v", in.MsgHdr)
 	}
 
@@ -13,10 +13,13 @@ type DnsResolverProxy interface {
 	AsAsync() AsyncDnsResolver
 	AsResolver() DnsResolver
 	WithCache() DnsResolverProxy
+	WithCacheStorage(conf DnsCacheConfig, storages ...DnsCacheStorage) DnsResolverProxy
 	WithDnssec() DnsResolverProxy
+	WithDnssecValidator(validator DnssecValidator) DnsResolverProxy
 	WithBadger(db Badger) DnsResolverProxy
 	WithLog() DnsResolverProxy
 	WithRateLimiting() DnsResolverProxy
+	WithRateLimitingConfig(conf DnsRateLimitingConfig) DnsResolverProxy
 }
 
 type DnsResolverProxyBase struct {
@@ -36,15 +39,23 @@ func (s *DnsResolverProxyBase) AsResolver() DnsResolver {
 }
 
 func (s *DnsResolverProxyBase) WithCache() DnsResolverProxy {
-	return NewDnsCacheRistretto(s.resolver)
+	return NewDnsCache(s.resolver, DefaultDnsCacheConfig(), NewRistretto(DefaultRistrettoConfig()))
+}
+
+func (s *DnsResolverProxyBase) WithCacheStorage(conf DnsCacheConfig, storages ...DnsCacheStorage) DnsResolverProxy {
+	return NewDnsCache(s.resolver, conf, storages...)
 }
 
 func (s *DnsResolverProxyBase) WithDnssec() DnsResolverProxy {
 	return NewDnssecResolver(s.resolver, NewDnssecValidator(s.resolver))
 }
 
+func (s *DnsResolverProxyBase) WithDnssecValidator(validator DnssecValidator) DnsResolverProxy {
+	return NewDnssecResolver(s.resolver, validator)
+}
+
 func (s *DnsResolverProxyBase) WithBadger(db Badger) DnsResolverProxy {
-	return NewDnsCacheBadger(s.resolver, db)
+	return NewDnsCache(s.resolver, DefaultDnsCacheConfig(), NewDnsCacheStorageAsync(db))
 }
 
 func (s *DnsResolverProxyBase) WithLog() DnsResolverProxy {
@@ -54,3 +65,7 @@ func (s *DnsResolverProxyBase) WithLog() DnsResolverProxy {
 func (s *DnsResolverProxyBase) WithRateLimiting() DnsResolverProxy {
 	return NewDnsRateLimiting(s.resolver)
 }
+
+func (s *DnsResolverProxyBase) WithRateLimitingConfig(conf DnsRateLimitingConfig) DnsResolverProxy {
+	return NewDnsRateLimitingWithConfig(s.resolver, conf)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"sync"
+	"time"
+)
+
+const (
+	prefetchThreshold = 10 // refresh an entry when less than 10% of its TTL remains.
+	prefetchMinHits   = 3  // number of hits for an entry to be considered popular.
+
+	DefaultCacheMinTTL = 0
+	DefaultCacheMaxTTL = 24 * time.Hour
+)
+
+// DnsCacheConfig bounds the lifetime of the cached entries.
+type DnsCacheConfig struct {
+	MinTTL time.Duration // entries are kept at least MinTTL in cache.
+	MaxTTL time.Duration // entries are kept at most MaxTTL in cache.
+}
+
+func DefaultDnsCacheConfig() DnsCacheConfig {
+	return DnsCacheConfig{
+		MinTTL: DefaultCacheMinTTL,
+		MaxTTL: DefaultCacheMaxTTL,
+	}
+}
+
+func (c DnsCacheConfig) clampTTL(ttl time.Duration) time.Duration {
+	if ttl < c.MinTTL {
+		return c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return ttl
+}
+
+// DnsCache caches the responses of the resolver in a list of storages, queried in order.
+// Tiering is achieved by listing the fastest storages first, ex: memory in front of disk.
+type DnsCache struct {
+	DnsResolverProxyBase
+	resolver    DnsResolverProxy
+	storages    []DnsCacheStorage
+	conf        DnsCacheConfig
+	prefetching *sync.Map
+}
+
+func NewDnsCache(resolver DnsResolverProxy, conf DnsCacheConfig, storages ...DnsCacheStorage) DnsResolverProxy {
+
+	var rsv DnsCache
+
+	defer transverse.Logger().Printf("%s initialized", &rsv)
+	defer rsv.initDnsResolverBase(&rsv)
+
+	rsv.resolver = resolver
+	rsv.storages = storages
+	rsv.conf = conf
+	rsv.prefetching = new(sync.Map)
+
+	return &rsv
+}
+
+func (rsv DnsCache) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
+
+	key := model.NewDnsCacheKey(rm)
+	entry, tier, found := rsv.lookup(key)
+	if !found {
+		nrm, err := rsv.resolver.Proxy(rm)
+		if err == nil {
+			rsv.store(key, nrm)
+		}
+		return nrm, err
+	}
+
+	// promote the entry to the faster storages.
+	rsv.promote(rsv.storages[:tier], key, entry)
+
+	if isPrefetchable(entry, entry.Hit(), entry.Remaining()) {
+		rsv.prefetch(key, rm)
+	}
+
+	nrm, err := entry.Value()
+	if err != nil {
+		return nrm, fmt.Errorf("found corrupted %s entry: %s", rsv.storages[tier].Name(), err.Error())
+	}
+
+	// adapt to the id of the request avoiding errors like
+	// ;; Warning: ID mismatch: expected ID 34825, got 13184
+	nrm.GetMsg().Id = rm.GetMsg().Id
+
+	return nrm.WithTTL(entry.Remaining()), nil
+}
+
+// lookup returns the first valid entry found in the storages, along with the index of its storage.
+func (rsv DnsCache) lookup(key string) (model.DnsCacheEntry, int, bool) {
+	for i, storage := range rsv.storages {
+		if entry, found := storage.Get(key); found && !entry.IsExpired() {
+			return entry, i, true
+		}
+	}
+	return model.DnsCacheEntry{}, 0, false
+}
+
+func (rsv DnsCache) store(key string, nrm model.DnsMsg) {
+	ttl := rsv.conf.clampTTL(nrm.GetTTL())
+	if ttl <= 0 {
+		return
+	}
+	entry, err := model.NewDnsCacheEntry(nrm, ttl)
+	if err != nil {
+		transverse.LoggerError().Printf("unable to pack cache entry: %s", err.Error())
+		return
+	}
+	rsv.promote(rsv.storages, key, entry)
+}
+
+func (rsv DnsCache) promote(storages []DnsCacheStorage, key string, entry model.DnsCacheEntry) {
+	for _, storage := range storages {
+		if err := storage.Set(key, entry); err != nil {
+			transverse.LoggerError().Printf("unable to store %s entry: %s", storage.Name(), err.Error())
+		}
+	}
+}
+
+// prefetch refreshes the entry in the background through the inner resolver,
+// so that popular names are renewed before they expire.
+// At most one refresh is running for a given key.
+func (rsv DnsCache) prefetch(key string, rm model.DnsMsg) {
+
+	if _, running := rsv.prefetching.LoadOrStore(key, true); running {
+		return
+	}
+
+	// the request belongs to the client, work on a copy of it.
+	m := model.NewDnsMsg(rm.GetMsg().Copy())
+
+	go func() {
+		defer rsv.prefetching.Delete(key)
+
+		transverse.Logger().Printf("prefetching %s", key)
+
+		nrm, err := rsv.resolver.Proxy(m)
+		if err != nil {
+			transverse.LoggerError().Printf("unable to prefetch %s: %s", key, err.Error())
+			return
+		}
+		rsv.store(key, nrm)
+	}()
+}
+
+// isPrefetchable tells if a cache hit is popular and close enough to its expiry to be refreshed.
+func isPrefetchable(entry model.DnsCacheEntry, hits uint32, remaining time.Duration) bool {
+	return hits >= prefetchMinHits && remaining*100 < entry.TTL()*prefetchThreshold
+}
+
+func (rsv DnsCache) String() string {
+	names := make([]string, len(rsv.storages))
+	for i, storage := range rsv.storages {
+		names[i] = storage.Name()
+	}
+	return fmt.Sprintf("DnsCache %v minTTL=%s maxTTL=%s", names, rsv.conf.MinTTL, rsv.conf.MaxTTL)
+}
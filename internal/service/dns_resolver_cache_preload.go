@@ -1,60 +1,178 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"golang-dns/internal/model"
 	"golang-dns/internal/transverse"
-	"log"
+	"golang.org/x/time/rate"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	DefaultPreloadWorkers  = 4
+	DefaultPreloadRate     = 10 // queries per second sent upstream to resolve expired entries.
+	DefaultPreloadProgress = 5 * time.Second
+)
+
+// DnsCachePreloadConfig bounds the load put on the upstream resolvers while preloading.
+type DnsCachePreloadConfig struct {
+	Workers  int           // number of concurrent queries.
+	Rate     rate.Limit    // maximum number of queries per second, unlimited when not positive.
+	Progress time.Duration // interval between progress reports.
+}
+
+func DefaultDnsCachePreloadConfig() DnsCachePreloadConfig {
+	return DnsCachePreloadConfig{
+		Workers:  DefaultPreloadWorkers,
+		Rate:     DefaultPreloadRate,
+		Progress: DefaultPreloadProgress,
+	}
+}
+
 type DnsCachePreload struct {
 	resolver DnsResolverProxy
+	cache    DnsCacheStorage
 	db       Badger
-	r        chan model.DnsCacheKey
+	conf     DnsCachePreloadConfig
+	limiter  *rate.Limiter
+}
+
+// DnsCachePreloadProgress counts the entries processed so far.
+type DnsCachePreloadProgress struct {
+	loaded   int64 // still valid entries loaded in cache.
+	resolved int64 // expired entries resolved again.
+	failed   int64 // expired entries that could not be resolved.
+	pending  int64 // expired entries waiting to be resolved.
 }
 
-func NewDnsCachePreload(resolver DnsResolverProxy, db Badger) DnsCachePreload {
+func NewDnsCachePreload(resolver DnsResolverProxy, cache DnsCacheStorage, db Badger, conf DnsCachePreloadConfig) DnsCachePreload {
 	var b DnsCachePreload
 	defer transverse.Logger().Printf("%s initialized", &b)
 
 	b.resolver = resolver
+	b.cache = cache
 	b.db = db
-	b.r = make(chan model.DnsCacheKey, nonBlockingChannel)
+	b.conf = conf
+	if b.conf.Workers < 1 {
+		b.conf.Workers = 1
+	}
+	b.limiter = rate.NewLimiter(rate.Inf, 1)
+	if conf.Rate > 0 {
+		b.limiter.SetLimit(conf.Rate)
+	}
 
 	return b
 }
 
-func (b DnsCachePreload) Preload() {
+// Preload fills the cache with the responses persisted in database.
+// Still valid responses are loaded in cache as is, expired ones are resolved again.
+// It returns once every entry has been processed, or when ctx is done.
+func (b DnsCachePreload) Preload(ctx context.Context) error {
 
-	// close channel hence terminating the ContinuouslyRead() function
-	defer close(b.r)
+	var progress DnsCachePreloadProgress
+
+	keys := make(chan model.DnsCacheKey, nonBlockingChannel)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.conf.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ContinuouslyResolve(ctx, keys, &progress)
+		}()
+	}
+
+	stop := b.Report(&progress)
+
+	err := b.db.Iterate(func(key string, entry model.DnsCacheEntry) error {
+
+		k := model.DnsCacheKey(key)
+
+		if !entry.IsExpired() {
+			err := b.cache.Set(key, entry)
+			if err == nil {
+				atomic.AddInt64(&progress.loaded, 1)
+				return nil
+			}
+			transverse.LoggerError().Printf("unable to load entry %s: %s", k, err.Error())
+		}
 
-	b.ContinuouslyRead()
+		atomic.AddInt64(&progress.pending, 1)
 
-	// preload in cache the dns queries that are stored in database.
-	err := b.db.IterateOverKeys(func(key []byte) {
-		b.r <- model.DnsCacheKey(key)
+		select {
+		case keys <- k:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	})
 
+	// close channel hence terminating the ContinuouslyResolve() function
+	close(keys)
+	wg.Wait()
+	stop()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("preload interrupted: %s: %s", &progress, err.Error())
 	}
+
+	transverse.Logger().Printf("preload completed: %s", &progress)
+
+	return nil
 }
 
-func (b DnsCachePreload) ContinuouslyRead() {
-	for i := 0; i < workers; i++ {
-		go func() {
-			for k := range b.r {
-				_, err := b.resolver.Proxy(k.ToDnsMsg())
-				if err != nil {
-					transverse.LoggerError().Printf("unable to query resolver: %s", err.Error())
-					continue
-				}
-			}
-		}()
+func (b DnsCachePreload) ContinuouslyResolve(ctx context.Context, keys chan model.DnsCacheKey, progress *DnsCachePreloadProgress) {
+	for k := range keys {
+
+		atomic.AddInt64(&progress.pending, -1)
+
+		if err := b.limiter.Wait(ctx); err != nil {
+			continue // cancelled, drain the remaining keys.
+		}
+
+		if _, err := b.resolver.Proxy(k.ToDnsMsg()); err != nil {
+			transverse.LoggerError().Printf("unable to query resolver: %s", err.Error())
+			atomic.AddInt64(&progress.failed, 1)
+			continue
+		}
+
+		atomic.AddInt64(&progress.resolved, 1)
 	}
 }
 
+// Report periodically logs the progress until the returned function is called.
+func (b DnsCachePreload) Report(progress *DnsCachePreloadProgress) func() {
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(b.conf.Progress)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				transverse.Logger().Printf("preloading: %s", progress)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
 func (b DnsCachePreload) String() string {
-	return fmt.Sprintf("DnsCachePreload %s", path)
+	return fmt.Sprintf("DnsCachePreload %s workers=%d rate=%v", b.db, b.conf.Workers, b.conf.Rate)
+}
+
+func (p *DnsCachePreloadProgress) String() string {
+	return fmt.Sprintf("loaded=%d resolved=%d failed=%d pending=%d",
+		atomic.LoadInt64(&p.loaded), atomic.LoadInt64(&p.resolved), atomic.LoadInt64(&p.failed), atomic.LoadInt64(&p.pending))
 }
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// DnsResolverStub answers every query locally with a single A record.
+type DnsResolverStub struct {
+	DnsResolverProxyBase
+	ttl     uint32
+	queries *int32
+}
+
+func NewDnsResolverStub(ttl uint32) *DnsResolverStub {
+	var rsv DnsResolverStub
+	rsv.initDnsResolverBase(&rsv)
+	rsv.ttl = ttl
+	rsv.queries = new(int32)
+	return &rsv
+}
+
+func (rsv DnsResolverStub) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
+	atomic.AddInt32(rsv.queries, 1)
+	m := new(dns.Msg)
+	m.SetReply(rm.GetMsg())
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: rm.GetQuestion().Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: rsv.ttl},
+		A:   []byte{127, 0, 0, 1},
+	}}
+	return model.NewDnsMsg(m), nil
+}
+
+func (rsv DnsResolverStub) Queries() int {
+	return int(atomic.LoadInt32(rsv.queries))
+}
+
+func TestDnsCacheHit(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	cache := stub.WithCache()
+
+	for i := 0; i < 5; i++ {
+		r, err := cache.Proxy(model.NewDnsMsg(h.Msg("example.com", dns.TypeA, dns.ClassINET)))
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		if len(r.GetRR()) != 1 {
+			t.Fatalf("got wrong response %v", r)
+		}
+	}
+
+	if stub.Queries() != 1 {
+		t.Fatalf("expect 1 upstream query, got %d", stub.Queries())
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCacheAdmin(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	storages := []DnsCacheStorage{NewRistretto(DefaultRistrettoConfig()), NewLru(1 << 20)}
+	cache := stub.WithCacheStorage(DefaultDnsCacheConfig(), storages...)
+	admin := NewDnsCacheAdmin(storages...)
+
+	for _, name := range []string{"example.com", "www.example.com", "example.org"} {
+		if _, err := cache.Proxy(model.NewDnsMsg(h.Msg(name, dns.TypeA, dns.ClassINET))); err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+	}
+
+	entries, err := admin.Entries("", false)
+	if err != nil || len(entries) != 6 {
+		t.Fatalf("expect 6 entries, got %v %v", entries, err)
+	}
+
+	entries, _ = admin.Entries("www.example.com", false)
+	if len(entries) != 2 || entries[0].TTL <= 0 || entries[0].TTL > 300 {
+		t.Fatalf("got wrong entries %v", entries)
+	}
+
+	count, err := admin.Evict("example.com", true)
+	if err != nil || count != 4 {
+		t.Fatalf("expect 4 evicted entries, got %d %v", count, err)
+	}
+
+	entries, _ = admin.Entries("", false)
+	if len(entries) != 2 || entries[0].Key != "example.org./1/1/do/-" {
+		t.Fatalf("got wrong entries %v", entries)
+	}
+
+	if err := admin.Flush(); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	entries, _ = admin.Entries("", false)
+	if len(entries) != 0 {
+		t.Fatalf("got wrong entries %v", entries)
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCacheTiering(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	memory, disk := NewLru(1<<20), NewLru(1<<20)
+	cache := stub.WithCacheStorage(DefaultDnsCacheConfig(), memory, disk)
+
+	rm := model.NewDnsMsg(h.Msg("example.com", dns.TypeA, dns.ClassINET))
+	key := model.NewDnsCacheKey(rm)
+
+	if _, err := cache.Proxy(rm); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	// entries evicted from memory are served by the next storage, then promoted again.
+	_ = memory.Delete(key)
+
+	if _, err := cache.Proxy(rm); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	if _, found := memory.Get(key); !found {
+		t.Fatalf("entry must be promoted to memory")
+	}
+
+	if stub.Queries() != 1 {
+		t.Fatalf("expect 1 upstream query, got %d", stub.Queries())
+	}
+
+	t.Logf("Success !")
+}
+
+// DnsCacheStorageSlow is a storage whose writes wait to be released, ex: a busy disk.
+type DnsCacheStorageSlow struct {
+	Lru
+	release chan struct{}
+}
+
+func (s DnsCacheStorageSlow) Set(key string, entry model.DnsCacheEntry) error {
+	<-s.release
+	return s.Lru.Set(key, entry)
+}
+
+func TestDnsCacheAsyncStorage(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	memory := NewLru(1 << 20)
+	disk := DnsCacheStorageSlow{Lru: NewLru(1 << 20), release: make(chan struct{})}
+	cache := stub.WithCacheStorage(DefaultDnsCacheConfig(), memory, NewDnsCacheStorageAsync(disk))
+
+	rm := model.NewDnsMsg(h.Msg("example.com", dns.TypeA, dns.ClassINET))
+	key := model.NewDnsCacheKey(rm)
+
+	done := make(chan error)
+	go func() {
+		_, err := cache.Proxy(rm)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("store must not wait for a slow storage")
+	}
+
+	// the memory tier is written right away, the slow one once released.
+	if _, found := memory.Get(key); !found {
+		t.Fatalf("entry must be stored in memory")
+	}
+
+	close(disk.release)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, found := disk.Get(key); found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry must be stored in the slow storage")
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestLruEviction(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	entry, err := model.NewDnsCacheEntry(mustProxy(t, stub), time.Minute)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	lru := NewLru(3 * entry.Cost())
+	for _, key := range []string{"a", "b", "c"} {
+		_ = lru.Set(key, entry)
+	}
+
+	_, _ = lru.Get("a") // "b" is now the least recently used
+	_ = lru.Set("d", entry)
+
+	if _, found := lru.Get("b"); found {
+		t.Fatalf("least recently used entry must be evicted")
+	}
+
+	for _, key := range []string{"a", "c", "d"} {
+		if _, found := lru.Get(key); !found {
+			t.Fatalf("entry %s must be present", key)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCachePrefetch(t *testing.T) {
+
+	stub := NewDnsResolverStub(300)
+	cache := stub.WithCache().(*DnsCache)
+
+	rm := model.NewDnsMsg(h.Msg("example.com", dns.TypeA, dns.ClassINET))
+	cache.prefetch(model.NewDnsCacheKey(rm), rm)
+
+	for i := 0; i < 100 && stub.Queries() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stub.Queries() != 1 {
+		t.Fatalf("expect 1 upstream query, got %d", stub.Queries())
+	}
+
+	t.Logf("Success !")
+}
+
+func TestIsPrefetchable(t *testing.T) {
+
+	stub := NewDnsResolverStub(100)
+	entry, err := model.NewDnsCacheEntry(mustProxy(t, stub), 100*time.Second)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	tests := []struct {
+		hits      uint32
+		remaining time.Duration
+		expect    bool
+	}{
+		{1, 5 * time.Second, false},
+		{prefetchMinHits, 50 * time.Second, false},
+		{prefetchMinHits, 10 * time.Second, false},
+		{prefetchMinHits, 5 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		if isPrefetchable(entry, tt.hits, tt.remaining) != tt.expect {
+			t.Fatalf("hits=%d remaining=%s: expect %v", tt.hits, tt.remaining, tt.expect)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsCacheClampTTL(t *testing.T) {
+
+	conf := DnsCacheConfig{MinTTL: 30 * time.Second, MaxTTL: time.Hour}
+
+	tests := []struct {
+		ttl    time.Duration
+		expect time.Duration
+	}{
+		{0, 30 * time.Second},
+		{10 * time.Second, 30 * time.Second},
+		{5 * time.Minute, 5 * time.Minute},
+		{48 * time.Hour, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if actual := conf.clampTTL(tt.ttl); actual != tt.expect {
+			t.Fatalf("ttl=%s: expect %s, got %s", tt.ttl, tt.expect, actual)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func mustProxy(t *testing.T, rsv DnsResolverProxy) model.DnsMsg {
+	r, err := rsv.Proxy(model.NewDnsMsg(h.Msg("example.com", dns.TypeA, dns.ClassINET)))
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return r
+}
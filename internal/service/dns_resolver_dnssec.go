@@ -23,17 +23,24 @@ func NewDnssecResolver(resolver DnsResolverProxy, validator DnssecValidator) Dns
 
 func (rsv DnssecResolver) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
 
+	// the names denied by the validated NSEC/NSEC3 records in cache are answered without querying upstream (RFC 8198).
+	if !rm.GetMsg().CheckingDisabled {
+		if nrm, found := rsv.validator.Synthesize(rm, model.DnssecValidateIfSigned); found {
+			return nrm, nil
+		}
+	}
+
 	in, err := rsv.resolver.Proxy(rm)
 	if err != nil {
 		return in, err
 	}
 
-	if in.IsRRSIG() {
-		err = rsv.validator.Verify(in)
-		return in, err
+	// the client disabled the checking, the data is passed through unvalidated (RFC 4035 §3.2.2).
+	if rm.GetMsg().CheckingDisabled {
+		return in, nil
 	}
 
-	return in, nil
+	return rsv.validator.ValidateWithPolicy(in, model.DnssecValidateIfSigned)
 }
 
 func (_ DnssecResolver) String() string {
@@ -23,17 +23,24 @@ func NewDnssecResolverEnforced(resolver DnsResolverProxy, validator DnssecValida
 
 func (rsv DnssecResolverEnforced) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
 
+	// the names denied by the validated NSEC/NSEC3 records in cache are answered without querying upstream (RFC 8198).
+	if !rm.GetMsg().CheckingDisabled {
+		if nrm, found := rsv.validator.Synthesize(rm, model.DnssecEnforce); found {
+			return nrm, nil
+		}
+	}
+
 	in, err := rsv.resolver.Proxy(rm)
 	if err != nil {
 		return in, err
 	}
 
-	if !in.IsRRSIG() {
-		return in, fmt.Errorf("no dnssec signature")
+	// the client disabled the checking, the data is passed through unvalidated (RFC 4035 §3.2.2).
+	if rm.GetMsg().CheckingDisabled {
+		return in, nil
 	}
 
-	err = rsv.validator.Verify(in)
-	return in, err
+	return rsv.validator.ValidateWithPolicy(in, model.DnssecEnforce)
 }
 
 func (_ DnssecResolverEnforced) String() string {
@@ -0,0 +1,543 @@
+package service
+
+import (
+	"crypto"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// DnsResolverZonesStub answers the queries locally with the prepared responses,
+// and with NXDOMAIN when there is none.
+type DnsResolverZonesStub struct {
+	DnsResolverProxyBase
+	responses map[string]*dns.Msg
+	queries   *int32
+}
+
+func NewDnsResolverZonesStub() *DnsResolverZonesStub {
+	var rsv DnsResolverZonesStub
+	rsv.initDnsResolverBase(&rsv)
+	rsv.responses = make(map[string]*dns.Msg)
+	rsv.queries = new(int32)
+	return &rsv
+}
+
+func (rsv DnsResolverZonesStub) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
+	atomic.AddInt32(rsv.queries, 1)
+	q := rm.GetQuestion()
+	m, found := rsv.responses[rsv.key(q.Name, q.Qtype)]
+	if !found {
+		m = new(dns.Msg)
+		m.SetRcode(rm.GetMsg(), dns.RcodeNameError)
+		return model.NewDnsMsg(m), nil
+	}
+	m = m.Copy()
+	m.Id = rm.GetMsg().Id
+	return model.NewDnsMsg(m), nil
+}
+
+// Queries returns the number of queries received since the last call.
+func (rsv DnsResolverZonesStub) Queries() int {
+	return int(atomic.SwapInt32(rsv.queries, 0))
+}
+
+// Add prepares the response to the question name/dnsType.
+func (rsv DnsResolverZonesStub) Add(name string, dnsType uint16, rcode int, answer, ns []dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dnsType)
+	m.Response = true
+	m.Rcode = rcode
+	m.Answer = answer
+	m.Ns = ns
+	rsv.responses[rsv.key(name, dnsType)] = m
+	return m
+}
+
+func (_ DnsResolverZonesStub) key(name string, dnsType uint16) string {
+	return strings.ToLower(name) + "/" + dns.TypeToString[dnsType]
+}
+
+func (_ DnsResolverZonesStub) String() string {
+	return "DnsResolverZonesStub"
+}
+
+// TestZone is a zone signed with a generated key.
+type TestZone struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+// NewTestZone serves the signed DNSKEY of the zone, and its DS signed by the parent zone if any.
+func NewTestZone(t *testing.T, stub *DnsResolverZonesStub, name string, parent *TestZone) TestZone {
+	var z TestZone
+	z.key, z.signer = NewTestZoneKey(t, name)
+	stub.Add(name, dns.TypeDNSKEY, dns.RcodeSuccess, z.Signed(t, z.key), nil)
+	if parent != nil {
+		ds := z.key.ToDS(dns.SHA256)
+		ds.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+		stub.Add(name, dns.TypeDS, dns.RcodeSuccess, parent.Signed(t, ds), nil)
+	}
+	return z
+}
+
+// Signed returns the RRset followed by its signature.
+func (z TestZone) Signed(t *testing.T, rrset ...dns.RR) []dns.RR {
+	return append(rrset, SignTestRRset(t, z.key, z.signer, rrset...))
+}
+
+func (z TestZone) Anchors() model.IanaAnchors {
+	ds := z.key.ToDS(dns.SHA256)
+	return model.IanaAnchors{KeyDigest: []model.IanaKeyDigest{{
+		KeyTag:     ds.KeyTag,
+		Algorithm:  ds.Algorithm,
+		DigestType: ds.DigestType,
+		Digest:     ds.Digest,
+	}}}
+}
+
+type TestZones struct {
+	stub    *DnsResolverZonesStub
+	root    TestZone
+	example TestZone
+	other   TestZone
+}
+
+// NewTestZones builds a signed root with the signed zones example. and other., and an unsigned delegation insecure.
+func NewTestZones(t *testing.T) TestZones {
+
+	var z TestZones
+
+	z.stub = NewDnsResolverZonesStub()
+
+	z.root = NewTestZone(t, z.stub, ".", nil)
+	z.example = NewTestZone(t, z.stub, "example.", &z.root)
+	z.other = NewTestZone(t, z.stub, "other.", &z.root)
+
+	z.stub.Add("insecure.", dns.TypeDS, dns.RcodeSuccess, nil, z.root.Signed(t,
+		MustRR(t, "insecure. 3600 IN NSEC other. NS RRSIG NSEC")))
+
+	z.stub.Add("www.example.", dns.TypeA, dns.RcodeSuccess, z.example.Signed(t,
+		MustRR(t, "www.example. 3600 IN A 127.0.0.1")), nil)
+
+	return z
+}
+
+func (z TestZones) Validator() DnssecValidator {
+	return NewDnssecValidatorFromIanaFile(z.stub, z.root.Anchors())
+}
+
+func NewTestNameError(t *testing.T, name string, example TestZone) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.Rcode = dns.RcodeNameError
+	m.Ns = append(m.Ns, example.Signed(t, MustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600"))...)
+	m.Ns = append(m.Ns, example.Signed(t, MustRR(t, "example. 3600 IN NSEC www.example. SOA NS RRSIG NSEC DNSKEY"))...)
+	m.Ns = append(m.Ns, example.Signed(t, MustRR(t, "www.example. 3600 IN NSEC example. A RRSIG NSEC"))...)
+	return m
+}
+
+func TestDnssecResolverNegative(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	stub := zones.stub
+
+	signed := NewTestNameError(t, "nope.example.", zones.example)
+	stub.Add("nope.example.", dns.TypeA, signed.Rcode, nil, signed.Ns)
+
+	stub.Add("forged.example.", dns.TypeA, dns.RcodeNameError, nil,
+		[]dns.RR{MustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600")})
+
+	stub.Add("nope.insecure.", dns.TypeA, dns.RcodeNameError, nil,
+		[]dns.RR{MustRR(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 3600 1209600 3600")})
+
+	validator := zones.Validator()
+
+	tests := []struct {
+		name      string
+		enforced  bool
+		valid     bool
+		validated bool
+	}{
+		{"www.example.", false, true, true},
+		{"nope.example.", false, true, true},
+		{"nope.example.", true, true, true},
+		{"forged.example.", false, false, false},
+		{"forged.example.", true, false, false},
+		{"nope.insecure.", false, true, false},
+		{"nope.insecure.", true, false, false},
+	}
+
+	for _, tt := range tests {
+
+		var resolver DnsResolverProxy = NewDnssecResolver(stub, validator)
+		if tt.enforced {
+			enforced := NewDnssecResolverEnforced(stub, validator)
+			resolver = &enforced
+		}
+
+		r, err := resolver.Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+
+		if tt.valid && err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s: not received any error", tt.name)
+		}
+		if err == nil && r.IsValidated() != tt.validated {
+			t.Fatalf("%s: expect validated=%v", tt.name, tt.validated)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResolverCname(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	example, other := zones.example, zones.other
+
+	cname := MustRR(t, "alias.example. 3600 IN CNAME cdn.other.")
+	a := MustRR(t, "cdn.other. 3600 IN A 127.0.0.2")
+	dname := MustRR(t, "old.example. 3600 IN DNAME other.")
+	synthesized := MustRR(t, "cdn.old.example. 3600 IN CNAME cdn.other.")
+
+	tampered := example.Signed(t, cname)
+	tampered[0] = MustRR(t, "alias.example. 3600 IN CNAME evil.other.")
+
+	tests := []struct {
+		name   string
+		answer []dns.RR
+		valid  bool
+	}{
+		{"alias.example.", append(example.Signed(t, cname), other.Signed(t, a)...), true},
+		{"alias.example.", append([]dns.RR{cname}, other.Signed(t, a)...), false},                                                        // unsigned link
+		{"alias.example.", append(other.Signed(t, cname), other.Signed(t, a)...), false},                                                 // signed by a foreign zone
+		{"alias.example.", append(tampered, other.Signed(t, MustRR(t, "evil.other. 3600 IN A 127.0.0.2"))...), false},                    // bogus link
+		{"alias.example.", append(example.Signed(t, cname), example.Signed(t, MustRR(t, "www.example. 3600 IN A 127.0.0.1"))...), false}, // not on the chain
+		{"cdn.old.example.", append(append(example.Signed(t, dname), synthesized), other.Signed(t, a)...), true},
+		{"cdn.old.example.", append(append(example.Signed(t, dname), MustRR(t, "cdn.old.example. 3600 IN CNAME evil.other.")), other.Signed(t, a)...), false},
+	}
+
+	validator := zones.Validator()
+
+	for i, tt := range tests {
+
+		zones.stub.Add(tt.name, dns.TypeA, dns.RcodeSuccess, tt.answer, nil)
+
+		r, err := NewDnssecResolver(zones.stub, validator).Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+
+		if tt.valid && (err != nil || !r.IsValidated()) {
+			t.Fatalf("%d: %s: received error: %v", i, tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%d: %s: not received any error", i, tt.name)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+// ExpandTestWildcard rewrites the owner of the signed wildcard RRset to name.
+func ExpandTestWildcard(name string, signed []dns.RR) []dns.RR {
+	expanded := make([]dns.RR, len(signed))
+	for i, v := range signed {
+		expanded[i] = dns.Copy(v)
+		expanded[i].Header().Name = name
+	}
+	return expanded
+}
+
+func TestDnssecResolverWildcard(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	example := zones.example
+
+	wildcard := example.Signed(t, MustRR(t, "*.example. 3600 IN A 127.0.0.3"))
+	proof := example.Signed(t, MustRR(t, "*.example. 3600 IN NSEC www.example. A RRSIG NSEC"))
+	wrongProof := example.Signed(t, MustRR(t, "example. 3600 IN NSEC *.example. SOA NS RRSIG NSEC DNSKEY"))
+	// NSEC records signed by another zone neither prove nor disprove the expansion.
+	otherProof := zones.other.Signed(t, MustRR(t, "*.example. 3600 IN NSEC www.example. A RRSIG NSEC"))
+	otherNsec := zones.other.Signed(t, MustRR(t, "other. 3600 IN NSEC other. SOA NS RRSIG NSEC DNSKEY"))
+
+	tests := []struct {
+		name   string
+		answer []dns.RR
+		ns     []dns.RR
+		valid  bool
+	}{
+		{"foo.example.", ExpandTestWildcard("foo.example.", wildcard), proof, true},
+		{"a.foo.example.", ExpandTestWildcard("a.foo.example.", wildcard), proof, true},
+		{"*.example.", wildcard, nil, true},
+		{"foo.example.", ExpandTestWildcard("foo.example.", wildcard), nil, false},        // no proof
+		{"foo.example.", ExpandTestWildcard("foo.example.", wildcard), wrongProof, false}, // proof does not cover the name
+		{"xyz.example.", ExpandTestWildcard("xyz.example.", wildcard), proof, false},      // the proof shows www.example. is the next name, xyz.example. is not covered
+		{"bar.example.", ExpandTestWildcard("bar.example.", wildcard), otherProof, false}, // proof signed by another zone
+		{"baz.example.", ExpandTestWildcard("baz.example.", wildcard), append(otherNsec, proof...), true},
+	}
+
+	validator := zones.Validator()
+
+	for i, tt := range tests {
+
+		zones.stub.Add(tt.name, dns.TypeA, dns.RcodeSuccess, tt.answer, tt.ns)
+
+		r, err := NewDnssecResolver(zones.stub, validator).Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+
+		if tt.valid && (err != nil || !r.IsValidated()) {
+			t.Fatalf("%d: %s: received error: %v", i, tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%d: %s: not received any error", i, tt.name)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResolverSections(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	example, other := zones.example, zones.other
+
+	a := example.Signed(t, MustRR(t, "www.example. 3600 IN A 127.0.0.1"))
+	a = a[:len(a):len(a)]
+	glue := MustRR(t, "ns.example. 3600 IN A 127.0.0.53")
+	tampered := example.Signed(t, MustRR(t, "ns2.example. 3600 IN A 127.0.0.54"))
+	tampered[0] = MustRR(t, "ns2.example. 3600 IN A 127.0.0.66")
+
+	tests := []struct {
+		name   string
+		answer []dns.RR
+		ns     []dns.RR
+		extra  []dns.RR
+		valid  bool
+		kept   int // records of the authority and additional sections passed to the client.
+	}{
+		{"www.example.", a, nil, example.Signed(t, glue), true, 2},
+		{"www.example.", a, example.Signed(t, MustRR(t, "example. 3600 IN NS ns.example.")), []dns.RR{glue}, true, 2}, // unsigned glue
+		{"www.example.", a, []dns.RR{MustRR(t, "example. 3600 IN NS ns.example.")}, nil, true, 0},                     // unsigned authority
+		{"www.example.", a, nil, tampered, true, 0},
+		{"www.example.", a, nil, other.Signed(t, glue), true, 0},                                        // signed by a foreign zone
+		{"www.example.", append(a, MustRR(t, "www.example. 3600 IN A 127.0.0.66")), nil, nil, false, 0}, // record smuggled into the signed RRset
+		{"www.example.", append(a, glue), nil, nil, false, 0},                                           // RRset not on the chain
+	}
+
+	validator := zones.Validator()
+
+	for i, tt := range tests {
+
+		m := zones.stub.Add(tt.name, dns.TypeA, dns.RcodeSuccess, tt.answer, tt.ns)
+		m.Extra = tt.extra
+		m.SetEdns0(4096, true)
+
+		r, err := NewDnssecResolver(zones.stub, validator).Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+
+		if tt.valid && (err != nil || !r.IsValidated()) {
+			t.Fatalf("%d: %s: received error: %v", i, tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%d: %s: not received any error", i, tt.name)
+		}
+		if err != nil {
+			continue
+		}
+
+		// the OPT record is always kept.
+		if kept := len(r.GetMsg().Ns) + len(r.GetMsg().Extra) - 1; kept != tt.kept || r.GetMsg().IsEdns0() == nil {
+			t.Fatalf("%d: %s: expect %d records kept, got %d", i, tt.name, tt.kept, kept)
+		}
+		if len(r.GetMsg().Answer) != len(tt.answer) {
+			t.Fatalf("%d: %s: expect the answer to be kept", i, tt.name)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResult(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	example := zones.example
+
+	expired := MustRR(t, "expired.example. 3600 IN A 127.0.0.1")
+	zones.stub.Add("expired.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{expired,
+		SignTestRRsetPeriod(t, example.key, example.signer, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), expired)}, nil)
+
+	zones.stub.Add("forged.example.", dns.TypeA, dns.RcodeNameError, nil,
+		[]dns.RR{MustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600")})
+
+	zones.stub.Add("nope.insecure.", dns.TypeA, dns.RcodeNameError, nil,
+		[]dns.RR{MustRR(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 3600 1209600 3600")})
+	zones.stub.Add("www.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+		MustRR(t, "www.insecure. 3600 IN A 127.0.0.1")}, nil)
+
+	// the signed denials of their DS records prove that www.example. and target.example. are not zone cuts.
+	zones.stub.Add("www.example.", dns.TypeDS, dns.RcodeSuccess, nil, example.Signed(t,
+		MustRR(t, "www.example. 3600 IN NSEC example. A RRSIG NSEC")))
+	zones.stub.Add("target.example.", dns.TypeDS, dns.RcodeSuccess, nil, example.Signed(t,
+		MustRR(t, "target.example. 3600 IN NSEC www.example. A RRSIG NSEC")))
+
+	// answers mixing signed and unsigned RRsets: the unsigned target is insecure only in an unsigned zone.
+	zones.stub.Add("cname.example.", dns.TypeA, dns.RcodeSuccess, append(example.Signed(t,
+		MustRR(t, "cname.example. 3600 IN CNAME www.insecure.")),
+		MustRR(t, "www.insecure. 3600 IN A 127.0.0.1")), nil)
+	zones.stub.Add("mixed.example.", dns.TypeA, dns.RcodeSuccess, append(example.Signed(t,
+		MustRR(t, "mixed.example. 3600 IN CNAME target.example.")),
+		MustRR(t, "target.example. 3600 IN A 127.0.0.1")), nil)
+	zones.stub.Add("alias.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+		MustRR(t, "alias.insecure. 3600 IN CNAME www.example."),
+		MustRR(t, "www.example. 3600 IN A 127.0.0.1")}, nil)
+
+	tests := []struct {
+		name   string
+		status model.DnssecStatus
+		ede    uint16
+	}{
+		{"www.example.", model.DnssecSecure, 0},
+		{"nope.insecure.", model.DnssecInsecure, 0},
+		{"www.insecure.", model.DnssecInsecure, 0},
+		{"cname.example.", model.DnssecInsecure, 0},
+		{"mixed.example.", model.DnssecBogus, dns.ExtendedErrorCodeRRSIGsMissing},
+		{"alias.insecure.", model.DnssecBogus, dns.ExtendedErrorCodeRRSIGsMissing},
+		{"forged.example.", model.DnssecBogus, dns.ExtendedErrorCodeRRSIGsMissing},
+		{"expired.example.", model.DnssecBogus, dns.ExtendedErrorCodeSignatureExpired},
+	}
+
+	resolver := NewDnssecResolver(zones.stub, zones.Validator())
+
+	for _, tt := range tests {
+
+		r, _ := resolver.Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+
+		result := r.GetDnssecResult()
+		if result.Status != tt.status {
+			t.Fatalf("%s: expect %s, got %s", tt.name, tt.status, result)
+		}
+		if tt.status == model.DnssecBogus && (result.Ede == nil || result.Ede.InfoCode != tt.ede) {
+			t.Fatalf("%s: expect EDE %s, got %s", tt.name, dns.ExtendedErrorCodeToString[tt.ede], result)
+		}
+	}
+
+	// the answer of a signed zone stripped of its signatures, ex: by an on-path attacker.
+	in, err := zones.stub.Proxy(model.NewDnsMsg(h.Msg("www.example.", dns.TypeA, dns.ClassINET)))
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	stripped := in.GetMsg().Copy()
+	stripped.Answer = h.CollectAll(stripped.Answer, dns.TypeA)
+
+	r, err := zones.Validator().Validate(model.NewDnsMsg(stripped))
+	if result := r.GetDnssecResult(); err == nil || result.Status != model.DnssecBogus || result.Ede.InfoCode != dns.ExtendedErrorCodeRRSIGsMissing {
+		t.Fatalf("www.example.: expect a stripped answer to be bogus, got %s", result)
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResolverCheckingDisabled(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+
+	// an answer which does not validate
+	zones.stub.Add("bogus.example.", dns.TypeA, dns.RcodeSuccess, zones.other.Signed(t,
+		MustRR(t, "bogus.example. 3600 IN A 127.0.0.1")), nil)
+
+	validator := zones.Validator()
+	enforced := NewDnssecResolverEnforced(zones.stub, validator)
+
+	for _, resolver := range []DnsResolverProxy{NewDnssecResolver(zones.stub, validator), &enforced} {
+
+		m := h.Msg("bogus.example.", dns.TypeA, dns.ClassINET)
+		if _, err := resolver.Proxy(model.NewDnsMsg(m)); err == nil {
+			t.Fatalf("%s: not received any error", resolver)
+		}
+
+		m.CheckingDisabled = true
+		r, err := resolver.Proxy(model.NewDnsMsg(m))
+		if err != nil {
+			t.Fatalf("%s: received error: %v", resolver, err.Error())
+		}
+		if r.GetDnssecResult().Status != model.DnssecIndeterminate {
+			t.Fatalf("%s: unchecked data must not be validated: %s", resolver, r.GetDnssecResult())
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecTrustCache(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+
+	zones.stub.Add("mail.example.", dns.TypeA, dns.RcodeSuccess, zones.example.Signed(t,
+		MustRR(t, "mail.example. 3600 IN A 127.0.0.1")), nil)
+	for _, name := range []string{"a.insecure.", "b.insecure."} {
+		zones.stub.Add(name, dns.TypeA, dns.RcodeNameError, nil,
+			[]dns.RR{MustRR(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 3600 1209600 3600")})
+	}
+
+	resolver := NewDnssecResolver(zones.stub, zones.Validator())
+
+	tests := []struct {
+		name    string
+		queries int // upstream queries, the answer included
+	}{
+		{"www.example.", 5},  // DNSKEY and DS of . and example.
+		{"mail.example.", 1}, // example. is trusted
+		{"a.insecure.", 3},   // DNSKEY and DS of insecure.
+		{"b.insecure.", 1},   // insecure. is known to be insecure
+	}
+
+	for _, tt := range tests {
+
+		if _, err := resolver.Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET))); err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+
+		if queries := zones.stub.Queries(); queries != tt.queries {
+			t.Fatalf("%s: expect %d upstream queries, got %d", tt.name, tt.queries, queries)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecTrustTTL(t *testing.T) {
+
+	key, signer := NewTestZoneKey(t, "example.")
+	now := time.Now().Truncate(time.Second)
+
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{key, SignTestRRsetPeriod(t, key, signer, now.Add(-time.Hour), now.Add(10*time.Minute), key)}
+
+	if ttl := trustTTL(now, model.NewDnsMsg(m)); ttl != 10*time.Minute {
+		t.Fatalf("trust must end with the signature, got %s", ttl)
+	}
+
+	m.Answer[0].Header().Ttl = 60
+	if ttl := trustTTL(now, model.NewDnsMsg(m)); ttl != time.Minute {
+		t.Fatalf("trust must end with the TTL, got %s", ttl)
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"sync"
+)
+
+// DnsResolverFixture answers the queries with recorded responses, so that the validation of a set of zones can be replayed offline.
+// A recorder forwards the questions which have not been recorded yet to its upstream resolver, and records their responses.
+type DnsResolverFixture struct {
+	DnsResolverProxyBase
+	upstream  DnsResolverProxy
+	mu        *sync.RWMutex
+	responses map[string]*dns.Msg
+}
+
+func NewDnsResolverFixture(fixtures model.DnsFixtures) (*DnsResolverFixture, error) {
+	var rsv DnsResolverFixture
+	defer t.Logger().Printf("%s initialized", &rsv)
+	rsv.initDnsResolverBase(&rsv)
+	rsv.mu = new(sync.RWMutex)
+	rsv.responses = make(map[string]*dns.Msg, len(fixtures))
+	for _, f := range fixtures {
+		m, err := f.Msg()
+		if err != nil {
+			return nil, err
+		}
+		rsv.responses[f.Key()] = m
+	}
+	return &rsv, nil
+}
+
+// NewDnsResolverRecorder records the responses of upstream.
+func NewDnsResolverRecorder(upstream DnsResolverProxy) *DnsResolverFixture {
+	rsv, _ := NewDnsResolverFixture(nil)
+	rsv.upstream = upstream
+	return rsv
+}
+
+func (rsv DnsResolverFixture) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
+
+	q := rm.GetQuestion()
+	key := model.DnsFixtureKey(q.Name, q.Qtype)
+
+	rsv.mu.RLock()
+	m, found := rsv.responses[key]
+	rsv.mu.RUnlock()
+
+	if found {
+		m = m.Copy()
+		m.Id = rm.GetMsg().Id
+		return model.NewDnsMsg(m), nil
+	}
+
+	if rsv.upstream == nil {
+		return rm, fmt.Errorf("no fixture for %s", key)
+	}
+
+	resp, err := rsv.upstream.Proxy(rm)
+	if err != nil {
+		return resp, err
+	}
+
+	rsv.mu.Lock()
+	defer rsv.mu.Unlock()
+	rsv.responses[key] = resp.GetMsg().Copy()
+
+	return resp, nil
+}
+
+// Fixtures returns the recorded responses.
+func (rsv DnsResolverFixture) Fixtures() model.DnsFixtures {
+	rsv.mu.RLock()
+	defer rsv.mu.RUnlock()
+	fixtures := make(model.DnsFixtures, 0, len(rsv.responses))
+	for _, m := range rsv.responses {
+		fixtures = append(fixtures, model.NewDnsFixture(model.NewDnsMsg(m)))
+	}
+	return fixtures
+}
+
+func (rsv DnsResolverFixture) String() string {
+	if rsv.upstream != nil {
+		return fmt.Sprintf("DnsResolverFixture recording %s", rsv.upstream)
+	}
+	return fmt.Sprintf("DnsResolverFixture")
+}
@@ -29,8 +29,8 @@ func (rsv DnsLog) Proxy(m model.DnsMsg) (model.DnsMsg, error) {
 		elapsed := time.Since(start).Round(1 * time.Millisecond)
 		q := m.GetQuestion()
 
-		if msg.IsRRSIG() {
-			transverse.Logger().Printf("%s +dnssec %s", elapsed, q.String())
+		if result := msg.GetDnssecResult(); result.Status != model.DnssecIndeterminate || result.Ede != nil {
+			transverse.Logger().Printf("%s +dnssec=%s %s", elapsed, result, q.String())
 			return
 		}
 
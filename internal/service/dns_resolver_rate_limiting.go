@@ -1,45 +1,243 @@
 package service
 
 import (
-	"context"
+	"container/list"
+	"errors"
 	"fmt"
+	"github.com/miekg/dns"
 	"golang-dns/internal/model"
 	"golang-dns/internal/transverse"
 	"golang.org/x/time/rate"
+	"net"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	DefaultRateLimit = 20
-	DefaultBurst     = 50
+	DefaultRateLimit        = 20 // queries per second of a client.
+	DefaultBurst            = 50
+	DefaultSubnetRateLimit  = 100 // queries per second of the clients of a subnet.
+	DefaultSubnetBurst      = 250
+	DefaultIPv4Prefix       = 24
+	DefaultIPv6Prefix       = 56
+	DefaultRateLimitClients = 10000
 )
 
+// ErrRateLimitDrop is returned when a query exceeding the rate limits must be dropped without any response.
+var ErrRateLimitDrop = errors.New("too many requests, query dropped")
+
+// RateLimitAction tells how the queries exceeding the rate limits are answered.
+type RateLimitAction int
+
+const (
+	RateLimitRefuse   RateLimitAction = iota // answered with REFUSED.
+	RateLimitDrop                            // not answered, the client times out.
+	RateLimitTruncate                        // answered empty with the TC bit, a genuine client retries over TCP where it is refused.
+)
+
+func ParseRateLimitAction(s string) (RateLimitAction, error) {
+	switch strings.ToLower(s) {
+	case "refuse":
+		return RateLimitRefuse, nil
+	case "drop":
+		return RateLimitDrop, nil
+	case "truncate":
+		return RateLimitTruncate, nil
+	}
+	return RateLimitRefuse, fmt.Errorf("unknown rate limit action: %s", s)
+}
+
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitTruncate:
+		return "truncate"
+	}
+	return "refuse"
+}
+
+// DnsRateLimitingConfig bounds the queries of each client, and of each subnet so that a client can not escape its limit
+// by spreading its queries over several addresses.
+type DnsRateLimitingConfig struct {
+	Rate        rate.Limit // maximum number of queries per second of a client, unlimited when not positive.
+	Burst       int
+	SubnetRate  rate.Limit // maximum number of queries per second of the clients of a subnet, unlimited when not positive.
+	SubnetBurst int
+	IPv4Prefix  int // length of the prefix of the IPv4 subnets.
+	IPv6Prefix  int // length of the prefix of the IPv6 subnets.
+	MaxClients  int // number of clients, and of subnets, tracked: the least recently seen ones are forgotten.
+	Action      RateLimitAction
+}
+
+func DefaultDnsRateLimitingConfig() DnsRateLimitingConfig {
+	return DnsRateLimitingConfig{
+		Rate:        DefaultRateLimit,
+		Burst:       DefaultBurst,
+		SubnetRate:  DefaultSubnetRateLimit,
+		SubnetBurst: DefaultSubnetBurst,
+		IPv4Prefix:  DefaultIPv4Prefix,
+		IPv6Prefix:  DefaultIPv6Prefix,
+		MaxClients:  DefaultRateLimitClients,
+		Action:      RateLimitRefuse,
+	}
+}
+
+// DnsRateLimiting holds a token bucket per client address and per subnet,
+// the queries exceeding one of them are answered following the action of the configuration without querying upstream.
+// The queries without client address, sent by the server itself, are not limited.
 type DnsRateLimiting struct {
 	DnsResolverProxyBase
 	resolver DnsResolverProxy
-	limiter  *rate.Limiter
+	conf     DnsRateLimitingConfig
+	clients  rateLimiters
+	subnets  rateLimiters
+}
+
+// rateLimiters holds token buckets by key, evicting the least recently used ones first.
+type rateLimiters struct {
+	mu    *sync.Mutex
+	items map[string]*list.Element
+	order *list.List // front is the most recently used.
+	limit rate.Limit
+	burst int
+	max   int
+}
+
+type rateLimiterItem struct {
+	key     string
+	limiter *rate.Limiter
 }
 
 func NewDnsRateLimiting(resolver DnsResolverProxy) DnsResolverProxy {
+	return NewDnsRateLimitingWithConfig(resolver, DefaultDnsRateLimitingConfig())
+}
+
+func NewDnsRateLimitingWithConfig(resolver DnsResolverProxy, conf DnsRateLimitingConfig) DnsResolverProxy {
 	var rsv DnsRateLimiting
 	defer transverse.Logger().Printf("%s initialized", &rsv)
 	defer rsv.initDnsResolverBase(&rsv)
 
+	if conf.MaxClients < 1 {
+		conf.MaxClients = 1
+	}
+
 	rsv.resolver = resolver
-	rsv.limiter = rate.NewLimiter(rate.Limit(DefaultRateLimit), DefaultBurst)
+	rsv.conf = conf
+	rsv.clients = newRateLimiters(conf.Rate, conf.Burst, conf.MaxClients)
+	rsv.subnets = newRateLimiters(conf.SubnetRate, conf.SubnetBurst, conf.MaxClients)
 
 	return &rsv
 }
 
 func (rsv DnsRateLimiting) Proxy(m model.DnsMsg) (model.DnsMsg, error) {
 
-	if err := rsv.limiter.Wait(context.Background()); err != nil {
-		return m, fmt.Errorf("too many requests: %s", err.Error())
+	ip := m.GetClient()
+	if ip == nil {
+		return rsv.resolver.Proxy(m)
+	}
+
+	// the token of the client is given back when the subnet is over its limit, so that it is not charged for the others.
+	cancel, allowed := rsv.clients.Reserve(ip.String())
+	if !allowed {
+		return rsv.limited(m)
+	}
+	if _, allowed = rsv.subnets.Reserve(rsv.subnet(ip)); !allowed {
+		cancel()
+		return rsv.limited(m)
+	}
+
+	return rsv.resolver.Proxy(m)
+}
+
+// limited answers a query exceeding the rate limits,
+// the queries received over TCP or HTTPS are refused instead of truncated: the client would not retry them.
+func (rsv DnsRateLimiting) limited(m model.DnsMsg) (model.DnsMsg, error) {
+
+	action := rsv.conf.Action
+	if action == RateLimitTruncate && m.IsStream() {
+		action = RateLimitRefuse
+	}
+	transverse.CountRateLimited(action.String())
+
+	r := new(dns.Msg)
+
+	switch action {
+	case RateLimitDrop:
+		return m, ErrRateLimitDrop
+	case RateLimitTruncate:
+		r.SetReply(m.GetMsg())
+		r.Truncated = true
+	default:
+		r.SetRcode(m.GetMsg(), dns.RcodeRefused)
 	}
 
-	msg, err := rsv.resolver.Proxy(m)
-	return msg, err
+	return model.NewDnsMsg(r), nil
+}
+
+// subnet returns the network of the client address, ex: 192.0.2.0/24.
+func (rsv DnsRateLimiting) subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(rsv.conf.IPv4Prefix, 32)), Mask: net.CIDRMask(rsv.conf.IPv4Prefix, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(rsv.conf.IPv6Prefix, 128)), Mask: net.CIDRMask(rsv.conf.IPv6Prefix, 128)}).String()
 }
 
 func (rsv DnsRateLimiting) String() string {
-	return fmt.Sprintf("DnsRateLimiting")
+	return fmt.Sprintf("DnsRateLimiting rate=%v burst=%d subnetRate=%v subnetBurst=%d action=%s",
+		rsv.conf.Rate, rsv.conf.Burst, rsv.conf.SubnetRate, rsv.conf.SubnetBurst, rsv.conf.Action)
+}
+
+func newRateLimiters(limit rate.Limit, burst, max int) rateLimiters {
+	return rateLimiters{
+		mu:    new(sync.Mutex),
+		items: make(map[string]*list.Element),
+		order: list.New(),
+		limit: limit,
+		burst: burst,
+		max:   max,
+	}
+}
+
+// Reserve takes a token from the bucket of key, created full when the key is not tracked.
+// It returns false when the bucket is empty, and the function giving the token back otherwise.
+func (l rateLimiters) Reserve(key string) (func(), bool) {
+
+	if l.limit <= 0 {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var limiter *rate.Limiter
+
+	if e, found := l.items[key]; found {
+		l.order.MoveToFront(e)
+		limiter = e.Value.(rateLimiterItem).limiter
+	} else {
+		if l.order.Len() >= l.max {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.items, oldest.Value.(rateLimiterItem).key)
+		}
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.items[key] = l.order.PushFront(rateLimiterItem{key: key, limiter: limiter})
+	}
+
+	// the reservation is cancelled at the time it was made, a later cancellation would not give back a token already due.
+	now := time.Now()
+	r := limiter.ReserveN(now, 1)
+	cancel := func() { r.CancelAt(now) }
+
+	if !r.OK() {
+		return nil, false
+	}
+	if r.DelayFrom(now) > 0 {
+		cancel()
+		return nil, false
+	}
+
+	return cancel, true
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"net"
+	"testing"
+)
+
+func TestDnsRateLimiting(t *testing.T) {
+
+	transverse.SetTest()
+
+	// the buckets do not refill during the test.
+	conf := DefaultDnsRateLimitingConfig()
+	conf.Rate, conf.Burst = 0.001, 2
+	conf.SubnetRate, conf.SubnetBurst = 0.001, 3
+
+	tests := []struct {
+		name     string
+		action   RateLimitAction
+		clients  []net.IP // clients of the successive queries.
+		expected int      // number of queries sent upstream.
+	}{
+		{"client limit", RateLimitRefuse, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1)}, 2},
+		{"subnet limit", RateLimitRefuse, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 3), net.IPv4(192, 0, 2, 4)}, 3},
+		{"other subnet", RateLimitRefuse, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1), net.IPv4(198, 51, 100, 1), net.IPv4(198, 51, 100, 1)}, 4},
+		{"ipv6 subnet", RateLimitRefuse, []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8:0:ff::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8:0:100::1")}, 4},
+		{"ipv6 subnet limit", RateLimitRefuse, []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3"), net.ParseIP("2001:db8::4")}, 3},
+		{"server queries", RateLimitRefuse, []net.IP{nil, nil, nil, nil}, 4},
+		{"drop", RateLimitDrop, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1)}, 2},
+		{"truncate", RateLimitTruncate, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1)}, 2},
+		{"truncate over tcp", RateLimitTruncate, []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1)}, 2},
+	}
+
+	for _, tt := range tests {
+
+		stub := NewDnsResolverStub(300)
+		conf.Action = tt.action
+		resolver := NewDnsRateLimitingWithConfig(stub, conf)
+
+		for _, ip := range tt.clients {
+
+			queries := stub.Queries()
+			req := h.Msg("example.com.", dns.TypeA, dns.ClassINET)
+			m := model.NewDnsMsg(req).WithClient(ip)
+			if tt.name == "truncate over tcp" {
+				m = m.WithStream()
+			}
+			r, err := resolver.Proxy(m)
+
+			if stub.Queries() > queries {
+				if err != nil {
+					t.Fatalf("%s: received error: %v", tt.name, err.Error())
+				}
+				continue
+			}
+
+			switch {
+			case tt.action == RateLimitDrop:
+				if !errors.Is(err, ErrRateLimitDrop) {
+					t.Fatalf("%s: expect the query to be dropped, received %v", tt.name, err)
+				}
+			case tt.action == RateLimitTruncate && !m.IsStream():
+				if err != nil || !r.GetMsg().Truncated || len(r.GetMsg().Answer) != 0 {
+					t.Fatalf("%s: expect a truncated response", tt.name)
+				}
+			default:
+				if err != nil || r.GetMsg().Rcode != dns.RcodeRefused {
+					t.Fatalf("%s: expect a refused response", tt.name)
+				}
+			}
+			if err == nil && r.GetMsg().Id != req.Id {
+				t.Fatalf("%s: expect id %d, got %d", tt.name, req.Id, r.GetMsg().Id)
+			}
+		}
+
+		if queries := stub.Queries(); queries != tt.expected {
+			t.Fatalf("%s: expect %d queries upstream, got %d", tt.name, tt.expected, queries)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsRateLimitingSubnetRefund(t *testing.T) {
+
+	transverse.SetTest()
+
+	conf := DefaultDnsRateLimitingConfig()
+	conf.Rate, conf.Burst = 0.001, 1
+	conf.SubnetRate, conf.SubnetBurst = 0.001, 1
+
+	stub := NewDnsResolverStub(300)
+	resolver := NewDnsRateLimitingWithConfig(stub, conf).(*DnsRateLimiting)
+
+	for _, ip := range []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)} {
+		if _, err := resolver.Proxy(model.NewDnsMsg(h.Msg("example.com.", dns.TypeA, dns.ClassINET)).WithClient(ip)); err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+	}
+	if queries := stub.Queries(); queries != 1 {
+		t.Fatalf("expect the subnet to be limited, got %d queries upstream", queries)
+	}
+
+	// the client refused by its subnet keeps its token.
+	if _, allowed := resolver.clients.Reserve("192.0.2.2"); !allowed {
+		t.Fatalf("expect the token of the client to be given back")
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnsRateLimitingEviction(t *testing.T) {
+
+	transverse.SetTest()
+
+	l := newRateLimiters(0.001, 1, 2)
+	allow := func(key string) bool {
+		_, allowed := l.Reserve(key)
+		return allowed
+	}
+
+	if !allow("a") || !allow("b") || allow("a") {
+		t.Fatalf("expect the bucket of a to be empty")
+	}
+
+	// c evicts b, the least recently used, a is still tracked.
+	if !allow("c") || allow("a") {
+		t.Fatalf("expect the bucket of a to be tracked")
+	}
+	if len(l.items) != 2 || l.order.Len() != 2 {
+		t.Fatalf("expect 2 buckets, got %d", len(l.items))
+	}
+
+	// a forgotten client starts with a full bucket.
+	if !allow("b") {
+		t.Fatalf("expect b to be forgotten")
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAggressiveCacheSize = 1 << 16 // NSEC and NSEC3 records.
+)
+
+// DnssecAggressiveCache keeps the NSEC and NSEC3 records of the validated negative responses,
+// so that the names and types they deny are answered without querying upstream (RFC 8198):
+// a flood of random sub-domains of a signed zone is answered from the ranges of names already proven not to exist.
+// The NSEC3 records with the opt-out flag are not kept, their ranges may hide unsigned delegations.
+type DnssecAggressiveCache struct {
+	mu      *sync.RWMutex
+	zones   map[string]*aggressiveZone
+	size    *int
+	maxSize int
+}
+
+// aggressiveZone holds the denial records of a zone along with its SOA, each of them followed by its signatures.
+// The records are kept sorted, so that the ones matching or covering a name are found by a binary search.
+type aggressiveZone struct {
+	soa   aggressiveRecord
+	nsec  []aggressiveRecord                 // by canonical owner name
+	nsec3 map[nsec3Params][]aggressiveRecord // by hash of the owner name, for each set of hashing parameters
+}
+
+type aggressiveRecord struct {
+	rr      []dns.RR
+	key     string // the owner name of a NSEC record, the hash of the owner name of a NSEC3 record.
+	expires time.Time
+}
+
+// nsec3Params are the parameters of the NSEC3 chain of a zone: the names are hashed once for each of them.
+type nsec3Params struct {
+	hash       uint8
+	iterations uint16
+	salt       string
+}
+
+func NewDnssecAggressiveCache(maxSize int) DnssecAggressiveCache {
+	var c DnssecAggressiveCache
+	defer t.Logger().Printf("%s initialized", &c)
+	c.mu = new(sync.RWMutex)
+	c.zones = make(map[string]*aggressiveZone)
+	c.size = new(int)
+	c.maxSize = maxSize
+	return c
+}
+
+// IsEnabled tells whether the cache has been created, the zero value disables the aggressive use of the denial records.
+func (c DnssecAggressiveCache) IsEnabled() bool {
+	return c.mu != nil
+}
+
+// Store keeps the signed denial records of a validated negative response,
+// until the first of the negative TTL of the zone, the TTL of the record, and the expiration of its signatures (RFC 8198 §5.4).
+func (c DnssecAggressiveCache) Store(rm model.DnsMsg, now time.Time) {
+
+	if !c.IsEnabled() || !rm.IsValidated() || !rm.IsNegative() {
+		return
+	}
+
+	var soa aggressiveRecord
+	denial := make([]aggressiveRecord, 0, 2)
+
+	for _, rrset := range model.NewDnsRRsets(rm.GetMsg().Ns) {
+
+		if len(rrset.Signatures) == 0 || len(rrset.RR) != 1 {
+			continue
+		}
+
+		record := aggressiveRecord{rr: rrset.AsRR()}
+
+		switch rr := rrset.RR[0].(type) {
+		case *dns.SOA:
+			record.expires = aggressiveExpiry(now, rr.Minttl, record.rr)
+			soa = record
+		case *dns.NSEC:
+			denial = append(denial, record)
+		case *dns.NSEC3:
+			if rr.Flags&nsec3OptOut == 0 {
+				denial = append(denial, record)
+			}
+		}
+	}
+
+	if soa.rr == nil || len(denial) == 0 {
+		return
+	}
+
+	zone := strings.ToLower(soa.rr[0].Header().Name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if *c.size+len(denial) > c.maxSize {
+		c.purge(now)
+	}
+	if *c.size+len(denial) > c.maxSize {
+		return
+	}
+
+	z, found := c.zones[zone]
+	if !found {
+		z = &aggressiveZone{nsec3: make(map[nsec3Params][]aggressiveRecord)}
+		c.zones[zone] = z
+	}
+	z.soa = soa
+	c.purgeZone(z, now)
+
+	for _, record := range denial {
+		record.expires = aggressiveExpiry(now, soa.rr[0].(*dns.SOA).Minttl, record.rr)
+		switch rr := record.rr[0].(type) {
+		case *dns.NSEC:
+			record.key = strings.ToLower(rr.Hdr.Name)
+			z.nsec = c.insert(z.nsec, record, func(a, b string) bool { return h.CanonicalCompare(a, b) < 0 })
+		case *dns.NSEC3:
+			record.key = strings.ToUpper(dns.SplitDomainName(rr.Hdr.Name)[0])
+			params := nsec3Params{hash: rr.Hash, iterations: rr.Iterations, salt: strings.ToUpper(rr.Salt)}
+			z.nsec3[params] = c.insert(z.nsec3[params], record, func(a, b string) bool { return a < b })
+		}
+	}
+}
+
+// insert adds the record to the records sorted by key, or replaces the one of the same key.
+func (c DnssecAggressiveCache) insert(records []aggressiveRecord, record aggressiveRecord, less func(a, b string) bool) []aggressiveRecord {
+
+	i := sort.Search(len(records), func(i int) bool { return !less(records[i].key, record.key) })
+	if i < len(records) && !less(record.key, records[i].key) {
+		records[i] = record
+		return records
+	}
+
+	*c.size++
+	records = append(records, aggressiveRecord{})
+	copy(records[i+1:], records[i:])
+	records[i] = record
+
+	return records
+}
+
+// Lookup synthesizes the NXDOMAIN or NODATA response to the request from the cached denial records of its zone, if they prove it.
+func (c DnssecAggressiveCache) Lookup(req *dns.Msg, now time.Time) (*dns.Msg, bool) {
+
+	if !c.IsEnabled() || len(req.Question) != 1 {
+		return nil, false
+	}
+
+	q := req.Question[0]
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name := strings.ToLower(q.Name)
+
+	var z *aggressiveZone
+	for deep := dns.CountLabel(name); deep >= 0 && z == nil; deep-- {
+		z = c.zones[h.SubZone(name, deep)]
+	}
+	if z == nil || !now.Before(z.soa.expires) {
+		return nil, false
+	}
+
+	rcode, proof := z.nsecProof(name, q.Qtype, now)
+	if proof == nil {
+		rcode, proof = z.nsec3Proof(name, q.Qtype, now)
+	}
+	if proof == nil {
+		return nil, false
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	m.RecursionAvailable = true
+
+	// the records are answered with the time they can still be trusted.
+	expires := z.soa.expires
+	for _, record := range proof {
+		if record.expires.Before(expires) {
+			expires = record.expires
+		}
+	}
+	ttl := uint32(expires.Sub(now) / time.Second)
+
+	for _, record := range append([]aggressiveRecord{z.soa}, proof...) {
+		for _, rr := range record.rr {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = ttl
+			m.Ns = append(m.Ns, rr)
+		}
+	}
+
+	return m, true
+}
+
+// nsecProof returns the NSEC records proving that the name, or the type of the name, does not exist.
+func (z *aggressiveZone) nsecProof(name string, dnsType uint16, now time.Time) (int, []aggressiveRecord) {
+
+	// the last record whose owner sorts before or is the name: it matches or covers the name.
+	find := func(name string) (aggressiveRecord, bool) {
+		i := sort.Search(len(z.nsec), func(i int) bool {
+			return h.CanonicalCompare(z.nsec[i].key, name) > 0
+		})
+		if i == 0 || !now.Before(z.nsec[i-1].expires) {
+			return aggressiveRecord{}, false
+		}
+		return z.nsec[i-1], true
+	}
+
+	record, found := find(name)
+	if !found {
+		return 0, nil
+	}
+	nsec := record.rr[0].(*dns.NSEC)
+
+	// the name exists without the type, or is an empty non-terminal.
+	if NsecProvesNoData(name, dnsType, []*dns.NSEC{nsec}) == nil {
+		return dns.RcodeSuccess, []aggressiveRecord{record}
+	}
+
+	if !NsecCovers(nsec, name) {
+		return 0, nil
+	}
+
+	// the wildcard of the closest encloser must be denied as well.
+	encloser := h.CommonAncestor(name, nsec.Hdr.Name)
+	if next := h.CommonAncestor(name, nsec.NextDomain); dns.CountLabel(next) > dns.CountLabel(encloser) {
+		encloser = next
+	}
+	wildcard, found := find(dns.Fqdn("*." + strings.TrimSuffix(encloser, ".")))
+	if !found {
+		return 0, nil
+	}
+
+	proof := []*dns.NSEC{nsec, wildcard.rr[0].(*dns.NSEC)}
+	if NsecProvesNameError(name, proof) != nil {
+		return 0, nil
+	}
+
+	if proof[0] == proof[1] {
+		return dns.RcodeNameError, []aggressiveRecord{record}
+	}
+	return dns.RcodeNameError, []aggressiveRecord{record, wildcard}
+}
+
+// nsec3Proof returns the NSEC3 records proving that the name, or the type of the name, does not exist (RFC 5155 §8.4 and §8.5):
+// the record matching the name, or the closest encloser proof along with the record covering the wildcard of the encloser.
+func (z *aggressiveZone) nsec3Proof(name string, dnsType uint16, now time.Time) (int, []aggressiveRecord) {
+	for params, records := range z.nsec3 {
+		if rcode, proof := nsec3ChainProof(params, records, name, dnsType, now); proof != nil {
+			return rcode, proof
+		}
+	}
+	return 0, nil
+}
+
+// nsec3ChainProof looks for the proof in the records of a NSEC3 chain, each name being hashed once.
+func nsec3ChainProof(params nsec3Params, records []aggressiveRecord, name string, dnsType uint16, now time.Time) (int, []aggressiveRecord) {
+
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	hashes := make(map[string]string)
+	hash := func(name string) string {
+		if v, found := hashes[name]; found {
+			return v
+		}
+		v := dns.HashName(name, params.hash, params.iterations, params.salt)
+		hashes[name] = v
+		return v
+	}
+
+	// the record whose owner hash is the hash of the name, or the one before it which covers the hash.
+	find := func(name string, matches bool) (aggressiveRecord, bool) {
+		hashed := hash(name)
+		if hashed == "" {
+			return aggressiveRecord{}, false
+		}
+		i := sort.Search(len(records), func(i int) bool { return records[i].key >= hashed })
+		match := i < len(records) && records[i].key == hashed
+		if match != matches {
+			return aggressiveRecord{}, false
+		}
+		if !matches {
+			// the last record of the chain covers the hashes before the first one.
+			i = (i - 1 + len(records)) % len(records)
+			if !nsec3CoversHash(records[i], hashed) {
+				return aggressiveRecord{}, false
+			}
+		}
+		if !now.Before(records[i].expires) {
+			return aggressiveRecord{}, false
+		}
+		return records[i], true
+	}
+
+	if record, found := find(name, true); found {
+		nsec3 := record.rr[0].(*dns.NSEC3)
+		if Nsec3HasType(nsec3, dnsType) || Nsec3HasType(nsec3, dns.TypeCNAME) {
+			return 0, nil
+		}
+		// the DS record lives in the parent zone, and the parent side of a delegation is only authoritative for the DS record.
+		if (dnsType == dns.TypeDS && Nsec3HasType(nsec3, dns.TypeSOA)) || (dnsType != dns.TypeDS && Nsec3IsDelegation(nsec3)) {
+			return 0, nil
+		}
+		return dns.RcodeSuccess, []aggressiveRecord{record}
+	}
+
+	// the closest encloser exists, the next closer name and the wildcard of the encloser do not.
+	labels := dns.CountLabel(name)
+	for deep := labels - 1; deep >= 0; deep-- {
+
+		encloser := h.SubZone(name, deep)
+		matching, found := find(encloser, true)
+		if !found {
+			continue
+		}
+		if Nsec3IsDelegation(matching.rr[0].(*dns.NSEC3)) {
+			return 0, nil
+		}
+
+		next, found := find(h.SubZone(name, deep+1), false)
+		if !found {
+			return 0, nil
+		}
+		wildcard, found := find(dns.Fqdn("*."+strings.TrimSuffix(encloser, ".")), false)
+		if !found {
+			return 0, nil
+		}
+
+		return dns.RcodeNameError, uniqueRecords(matching, next, wildcard)
+	}
+
+	return 0, nil
+}
+
+// nsec3CoversHash tells if the hash sorts between the owner hash and the next hash of the record,
+// the last record of the chain wrapping around to the first one.
+func nsec3CoversHash(record aggressiveRecord, hashed string) bool {
+	next := strings.ToUpper(record.rr[0].(*dns.NSEC3).NextDomain)
+	if record.key < next {
+		return record.key < hashed && hashed < next
+	}
+	return record.key < hashed || hashed < next
+}
+
+func uniqueRecords(records ...aggressiveRecord) []aggressiveRecord {
+	unique := make([]aggressiveRecord, 0, len(records))
+	seen := make(map[string]bool)
+	for _, record := range records {
+		owner := strings.ToLower(record.rr[0].Header().Name)
+		if !seen[owner] {
+			seen[owner] = true
+			unique = append(unique, record)
+		}
+	}
+	return unique
+}
+
+// purge removes the expired records, and the zones left without any.
+func (c DnssecAggressiveCache) purge(now time.Time) {
+	for zone, z := range c.zones {
+		if c.purgeZone(z, now); len(z.nsec)+len(z.nsec3) == 0 {
+			delete(c.zones, zone)
+		}
+	}
+}
+
+// purgeZone removes the expired records of the zone, all of them when its SOA has expired.
+func (c DnssecAggressiveCache) purgeZone(z *aggressiveZone, now time.Time) {
+	z.nsec = c.unexpired(z.nsec, z.soa, now)
+	for params, records := range z.nsec3 {
+		if z.nsec3[params] = c.unexpired(records, z.soa, now); len(z.nsec3[params]) == 0 {
+			delete(z.nsec3, params)
+		}
+	}
+}
+
+// unexpired keeps the records which have not expired, in their order.
+func (c DnssecAggressiveCache) unexpired(records []aggressiveRecord, soa aggressiveRecord, now time.Time) []aggressiveRecord {
+	kept := records[:0]
+	for _, record := range records {
+		if now.Before(record.expires) && now.Before(soa.expires) {
+			kept = append(kept, record)
+			continue
+		}
+		*c.size--
+	}
+	return kept
+}
+
+// aggressiveExpiry returns the time the record can be trusted until:
+// the first of the expiry of its TTL, of the negative TTL of the zone, and of its signatures.
+func aggressiveExpiry(now time.Time, negativeTTL uint32, rr []dns.RR) time.Time {
+
+	ttl := time.Duration(negativeTTL) * time.Second
+	if d := time.Duration(rr[0].Header().Ttl) * time.Second; d < ttl {
+		ttl = d
+	}
+	if d := trustTTL(now, model.NewDnsMsg(&dns.Msg{Answer: rr})); d < ttl {
+		ttl = d
+	}
+
+	return now.Add(ttl)
+}
+
+func (c DnssecAggressiveCache) String() string {
+	return fmt.Sprintf("DnssecAggressiveCache maxSize=%d", c.maxSize)
+}
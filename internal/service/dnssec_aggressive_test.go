@@ -0,0 +1,163 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"testing"
+	"time"
+)
+
+func TestDnssecAggressiveCache(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	stub := zones.stub
+
+	signed := NewTestNameError(t, "nope.example.", zones.example)
+	stub.Add("nope.example.", dns.TypeA, signed.Rcode, nil, signed.Ns)
+	stub.Add("nope.insecure.", dns.TypeA, dns.RcodeNameError, nil,
+		[]dns.RR{MustRR(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 3600 1209600 3600")})
+
+	validator := zones.Validator().WithAggressiveCache(NewDnssecAggressiveCache(DefaultAggressiveCacheSize))
+	resolver := NewDnssecResolver(stub, validator)
+
+	// the validated NSEC records of example. deny every name but example. and www.example.
+	if _, err := resolver.Proxy(model.NewDnsMsg(h.Msg("nope.example.", dns.TypeA, dns.ClassINET))); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	stub.Queries()
+
+	tests := []struct {
+		name        string
+		dnsType     uint16
+		cd          bool
+		rcode       int
+		synthesized bool
+	}{
+		{"random-4f2a.example.", dns.TypeA, false, dns.RcodeNameError, true},
+		{"zzz.example.", dns.TypeMX, false, dns.RcodeNameError, true},
+		{"a.b.example.", dns.TypeA, false, dns.RcodeNameError, true},
+		{"www.example.", dns.TypeAAAA, false, dns.RcodeSuccess, true},        // no data
+		{"www.example.", dns.TypeA, false, dns.RcodeSuccess, false},          // the NSEC record proves the type exists
+		{"random-4f2a.example.", dns.TypeA, true, dns.RcodeNameError, false}, // checking disabled
+		{"nope.insecure.", dns.TypeA, false, dns.RcodeNameError, false},
+	}
+
+	for _, tt := range tests {
+
+		req := h.Msg(tt.name, tt.dnsType, dns.ClassINET)
+		req.CheckingDisabled = tt.cd
+
+		r, err := resolver.Proxy(model.NewDnsMsg(req))
+		if err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+		if r.GetMsg().Rcode != tt.rcode {
+			t.Fatalf("%s: expect rcode %s, got %s", tt.name, dns.RcodeToString[tt.rcode], dns.RcodeToString[r.GetMsg().Rcode])
+		}
+		if r.GetMsg().Id != req.Id {
+			t.Fatalf("%s: expect id %d, got %d", tt.name, req.Id, r.GetMsg().Id)
+		}
+		if queries := stub.Queries(); (queries == 0) != tt.synthesized {
+			t.Fatalf("%s: expect synthesized=%v, upstream received %d queries", tt.name, tt.synthesized, queries)
+		}
+		if !tt.synthesized {
+			continue
+		}
+
+		// the synthesized response holds the signed proof, it validates on its own.
+		if !r.IsValidated() {
+			t.Fatalf("%s: expect validated response", tt.name)
+		}
+		if err := validator.VerifyNegative(r); err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+		if ttl := r.GetMsg().Ns[0].Header().Ttl; ttl == 0 || ttl > 3600 {
+			t.Fatalf("%s: unexpected ttl %d", tt.name, ttl)
+		}
+	}
+
+	// past the negative TTL of the zone the records are no longer used.
+	later := NewDnssecResolver(stub, validator.WithClock(transverse.FixedClock(time.Now().Add(2*time.Hour))))
+	_, _ = later.Proxy(model.NewDnsMsg(h.Msg("random-4f2a.example.", dns.TypeA, dns.ClassINET)))
+	if stub.Queries() == 0 {
+		t.Fatalf("expect expired records not to be used")
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecAggressiveCacheNsec3(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+	stub := zones.stub
+
+	names := map[string][]uint16{
+		"example.":     {dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"www.example.": {dns.TypeA},
+	}
+	ns := zones.example.Signed(t, MustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600"))
+	for _, v := range NewTestNsec3Chain("example.", false, 0, names) {
+		ns = append(ns, zones.example.Signed(t, v)...)
+	}
+	stub.Add("nope.example.", dns.TypeA, dns.RcodeNameError, nil, ns)
+
+	cache := NewDnssecAggressiveCache(DefaultAggressiveCacheSize)
+	validator := zones.Validator().WithAggressiveCache(cache)
+	resolver := NewDnssecResolver(stub, validator)
+
+	// the validated NSEC3 chain of example. denies every name but example. and www.example.
+	for i := 0; i < 2; i++ {
+		if _, err := resolver.Proxy(model.NewDnsMsg(h.Msg("nope.example.", dns.TypeA, dns.ClassINET))); err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+	}
+	if *cache.size != len(names) {
+		t.Fatalf("expect %d records stored once, got %d", len(names), *cache.size)
+	}
+	stub.Queries()
+
+	tests := []struct {
+		name        string
+		dnsType     uint16
+		rcode       int
+		synthesized bool
+	}{
+		{"random-4f2a.example.", dns.TypeA, dns.RcodeNameError, true},
+		{"a.www.example.", dns.TypeA, dns.RcodeNameError, true},
+		{"www.example.", dns.TypeAAAA, dns.RcodeSuccess, true}, // no data
+		{"www.example.", dns.TypeA, dns.RcodeSuccess, false},   // the NSEC3 record proves the type exists
+	}
+
+	for _, tt := range tests {
+
+		r, err := resolver.Proxy(model.NewDnsMsg(h.Msg(tt.name, tt.dnsType, dns.ClassINET)))
+		if err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+		if r.GetMsg().Rcode != tt.rcode {
+			t.Fatalf("%s: expect rcode %s, got %s", tt.name, dns.RcodeToString[tt.rcode], dns.RcodeToString[r.GetMsg().Rcode])
+		}
+		if queries := stub.Queries(); (queries == 0) != tt.synthesized {
+			t.Fatalf("%s: expect synthesized=%v, upstream received %d queries", tt.name, tt.synthesized, queries)
+		}
+		if tt.synthesized {
+			if err := validator.VerifyNegative(r); err != nil {
+				t.Fatalf("%s: received error: %v", tt.name, err.Error())
+			}
+		}
+	}
+
+	// the expired records are removed along with their zone.
+	cache.purge(time.Now().Add(2 * time.Hour))
+	if *cache.size != 0 || len(cache.zones) != 0 {
+		t.Fatalf("expect the expired records to be removed, got %d", *cache.size)
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	t "golang-dns/internal/transverse"
+	"sync"
+)
+
+// DnssecSupport tells how the validator handles a DNSSEC algorithm or a DS digest type.
+type DnssecSupport int
+
+const (
+	DnssecUnsupported DnssecSupport = iota // not implemented, the zones using it only are insecure (RFC 4035 §5.2).
+	DnssecSupported                        // used for the validation.
+	DnssecRejected                         // deprecated, the zones using it only are bogus.
+)
+
+func (s DnssecSupport) String() string {
+	switch s {
+	case DnssecSupported:
+		return "supported"
+	case DnssecRejected:
+		return "rejected"
+	}
+	return "unsupported"
+}
+
+const (
+	edeUnsupportedNsec3Iterations = 27 // extended DNS error of RFC 9276 §3.2, not yet defined by miekg/dns.
+)
+
+// DnssecAlgorithmPolicy lists the DNSKEY algorithms and the DS digest types accepted by the validator,
+// unlisted ones are unsupported.
+// The NSEC3 records hashed with more than Nsec3Iterations iterations prove an insecure denial,
+// with more than Nsec3MaxIterations a bogus one (RFC 9276 §3.2).
+type DnssecAlgorithmPolicy struct {
+	Algorithms         map[uint8]DnssecSupport
+	Digests            map[uint8]DnssecSupport
+	Nsec3Iterations    uint16
+	Nsec3MaxIterations uint16
+}
+
+// DefaultDnssecAlgorithmPolicy follows the validation recommendations of RFC 8624:
+// the SHA-1 based algorithms and digests are still validated, RSAMD5 and DSA are rejected.
+func DefaultDnssecAlgorithmPolicy() DnssecAlgorithmPolicy {
+	return DnssecAlgorithmPolicy{
+		Nsec3Iterations:    100,
+		Nsec3MaxIterations: 500,
+		Algorithms: map[uint8]DnssecSupport{
+			dns.RSAMD5:           DnssecRejected,
+			dns.DSA:              DnssecRejected,
+			dns.RSASHA1:          DnssecSupported,
+			dns.DSANSEC3SHA1:     DnssecRejected,
+			dns.RSASHA1NSEC3SHA1: DnssecSupported,
+			dns.RSASHA256:        DnssecSupported,
+			dns.RSASHA512:        DnssecSupported,
+			dns.ECDSAP256SHA256:  DnssecSupported,
+			dns.ECDSAP384SHA384:  DnssecSupported,
+			dns.ED25519:          DnssecSupported,
+			dns.ED448:            DnssecUnsupported, // not implemented by miekg/dns.
+		},
+		Digests: map[uint8]DnssecSupport{
+			dns.SHA1:   DnssecSupported,
+			dns.SHA256: DnssecSupported,
+			dns.SHA384: DnssecSupported,
+		},
+	}
+}
+
+// WithoutSha1 stops validating the SHA-1 based algorithms and digests,
+// the zones signed with them only are insecure.
+func (p DnssecAlgorithmPolicy) WithoutSha1() DnssecAlgorithmPolicy {
+	algorithms := make(map[uint8]DnssecSupport, len(p.Algorithms))
+	for k, v := range p.Algorithms {
+		algorithms[k] = v
+	}
+	digests := make(map[uint8]DnssecSupport, len(p.Digests))
+	for k, v := range p.Digests {
+		digests[k] = v
+	}
+	algorithms[dns.RSASHA1] = DnssecUnsupported
+	algorithms[dns.RSASHA1NSEC3SHA1] = DnssecUnsupported
+	digests[dns.SHA1] = DnssecUnsupported
+	p.Algorithms, p.Digests = algorithms, digests
+	return p
+}
+
+func (p DnssecAlgorithmPolicy) Algorithm(algorithm uint8) DnssecSupport {
+	return p.Algorithms[algorithm]
+}
+
+func (p DnssecAlgorithmPolicy) Digest(digestType uint8) DnssecSupport {
+	return p.Digests[digestType]
+}
+
+// VerifyAlgorithm returns an error when the algorithm is not supported, wrapping ErrInsecureDelegation unless it is rejected.
+func (p DnssecAlgorithmPolicy) VerifyAlgorithm(algorithm uint8) error {
+	switch s := p.Algorithm(algorithm); s {
+	case DnssecSupported:
+		return nil
+	case DnssecRejected:
+		return NewDnssecError(dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm, "%s algorithm: %s", s, algorithmName(algorithm))
+	default:
+		return NewDnssecError(dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm, "%s algorithm: %s: %w", s, algorithmName(algorithm), ErrInsecureDelegation)
+	}
+}
+
+// VerifyNsec3Iterations returns an error when the NSEC3 records hashed with that many iterations can not prove a secure denial,
+// the hashing being too expensive.
+func (p DnssecAlgorithmPolicy) VerifyNsec3Iterations(iterations uint16) error {
+	switch {
+	case iterations > p.Nsec3MaxIterations:
+		return NewDnssecError(edeUnsupportedNsec3Iterations, "NSEC3 iterations %d exceed %d", iterations, p.Nsec3MaxIterations)
+	case iterations > p.Nsec3Iterations:
+		return NewDnssecError(edeUnsupportedNsec3Iterations, "NSEC3 iterations %d exceed %d: %w", iterations, p.Nsec3Iterations, ErrInsecureDelegation)
+	}
+	return nil
+}
+
+// SelectDS returns the DS records usable to validate a zone, with the strongest digest type published (RFC 4509 §3).
+// The delegation is insecure when none of the DS records is supported, bogus when some are rejected.
+func (p DnssecAlgorithmPolicy) SelectDS(zone string, ds []*dns.DS) ([]*dns.DS, error) {
+
+	usable := make([]*dns.DS, 0, len(ds))
+	rejected := error(nil)
+	strongest := 0
+
+	for _, v := range ds {
+		algorithm, digest := p.Algorithm(v.Algorithm), p.Digest(v.DigestType)
+		switch {
+		case algorithm == DnssecRejected:
+			rejected = NewDnssecError(dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm, "zone: %s : rejected DS algorithm: %s", zone, algorithmName(v.Algorithm))
+		case digest == DnssecRejected:
+			rejected = NewDnssecError(dns.ExtendedErrorCodeUnsupportedDSDigestType, "zone: %s : rejected DS digest type: %s", zone, digestName(v.DigestType))
+		case algorithm == DnssecSupported && digest == DnssecSupported:
+			usable = append(usable, v)
+			if s := digestStrength(v.DigestType); s > strongest {
+				strongest = s
+			}
+		}
+	}
+
+	if len(usable) == 0 && rejected != nil {
+		return nil, rejected
+	}
+	if len(usable) == 0 {
+		return nil, NewDnssecError(dns.ExtendedErrorCodeUnsupportedDSDigestType, "zone: %s : no supported DS: %w", zone, ErrInsecureDelegation)
+	}
+
+	// a weaker digest is ignored when a stronger one is published, to prevent downgrade attacks.
+	selected := make([]*dns.DS, 0, len(usable))
+	for _, v := range usable {
+		if digestStrength(v.DigestType) == strongest {
+			selected = append(selected, v)
+		}
+	}
+
+	return selected, nil
+}
+
+func (p DnssecAlgorithmPolicy) String() string {
+	return fmt.Sprintf("DnssecAlgorithmPolicy algorithms=%d digests=%d", len(p.Algorithms), len(p.Digests))
+}
+
+func digestStrength(digestType uint8) int {
+	switch digestType {
+	case dns.SHA1:
+		return 1
+	case dns.SHA256:
+		return 2
+	case dns.SHA384:
+		return 3
+	}
+	return 0
+}
+
+func algorithmName(algorithm uint8) string {
+	if s, found := dns.AlgorithmToString[algorithm]; found {
+		return s
+	}
+	return fmt.Sprintf("%d", algorithm)
+}
+
+func digestName(digestType uint8) string {
+	if s, found := dns.HashToString[digestType]; found {
+		return s
+	}
+	return fmt.Sprintf("%d", digestType)
+}
+
+var (
+	algorithmPolicyMu = new(sync.RWMutex)
+	algorithmPolicy   = DefaultDnssecAlgorithmPolicy()
+)
+
+// SetDnssecAlgorithmPolicy replaces the algorithm policy of the validators.
+func SetDnssecAlgorithmPolicy(p DnssecAlgorithmPolicy) {
+	algorithmPolicyMu.Lock()
+	defer algorithmPolicyMu.Unlock()
+	algorithmPolicy = p
+	t.Logger().Printf("%s set", p)
+}
+
+// GetDnssecAlgorithmPolicy returns the algorithm policy of the validators.
+func GetDnssecAlgorithmPolicy() DnssecAlgorithmPolicy {
+	algorithmPolicyMu.RLock()
+	defer algorithmPolicyMu.RUnlock()
+	return algorithmPolicy
+}
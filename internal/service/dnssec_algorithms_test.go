@@ -0,0 +1,230 @@
+package service
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"testing"
+	"time"
+)
+
+func TestSelectDS(t *testing.T) {
+
+	key, _ := NewTestZoneKey(t, "example.")
+	sha1, sha256, sha384 := key.ToDS(dns.SHA1), key.ToDS(dns.SHA256), key.ToDS(dns.SHA384)
+	unsupported := key.ToDS(dns.SHA256)
+	unsupported.Algorithm = dns.ED448
+	gost := key.ToDS(dns.SHA256)
+	gost.DigestType = dns.GOST94
+	md5 := key.ToDS(dns.SHA256)
+	md5.Algorithm = dns.RSAMD5
+
+	tests := []struct {
+		name     string
+		policy   DnssecAlgorithmPolicy
+		ds       []*dns.DS
+		expected []*dns.DS
+		insecure bool
+	}{
+		{"strongest digest", DefaultDnssecAlgorithmPolicy(), []*dns.DS{sha256, sha384}, []*dns.DS{sha384}, false},
+		{"sha-1 supported", DefaultDnssecAlgorithmPolicy(), []*dns.DS{sha1}, []*dns.DS{sha1}, false},
+		{"sha-1 weaker", DefaultDnssecAlgorithmPolicy(), []*dns.DS{sha1, sha256}, []*dns.DS{sha256}, false},
+		{"sha-1 disabled", DefaultDnssecAlgorithmPolicy().WithoutSha1(), []*dns.DS{sha1}, nil, true},
+		{"sha-1 ignored", DefaultDnssecAlgorithmPolicy().WithoutSha1(), []*dns.DS{sha1, sha256}, []*dns.DS{sha256}, false},
+		{"rejected algorithm", DefaultDnssecAlgorithmPolicy(), []*dns.DS{md5}, nil, false},
+		{"unsupported algorithm", DefaultDnssecAlgorithmPolicy(), []*dns.DS{unsupported}, nil, true},
+		{"unsupported digest", DefaultDnssecAlgorithmPolicy(), []*dns.DS{gost}, nil, true},
+		{"unsupported and supported", DefaultDnssecAlgorithmPolicy(), []*dns.DS{unsupported, sha256}, []*dns.DS{sha256}, false},
+	}
+
+	for _, tt := range tests {
+
+		selected, err := tt.policy.SelectDS("example.", tt.ds)
+
+		if tt.insecure != errors.Is(err, ErrInsecureDelegation) {
+			t.Fatalf("%s: expected insecure=%v, received %v", tt.name, tt.insecure, err)
+		}
+		if tt.expected == nil && err == nil {
+			t.Fatalf("%s: not received any error", tt.name)
+		}
+		if len(selected) != len(tt.expected) {
+			t.Fatalf("%s: expected %v, received %v", tt.name, tt.expected, selected)
+		}
+		for i := range selected {
+			if selected[i] != tt.expected[i] {
+				t.Fatalf("%s: expected %v, received %v", tt.name, tt.expected, selected)
+			}
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestUnsupportedSignatures(t *testing.T) {
+
+	transverse.SetTest()
+
+	key, signer := NewTestZoneKey(t, "example.")
+	rr := MustRR(t, "www.example. 3600 IN A 127.0.0.1")
+
+	// a signature of an algorithm the validator does not implement, made with a key of the same algorithm.
+	unsupported := func(algorithm uint8) *dns.RRSIG {
+		rrsig := SignTestRRset(t, key, signer, rr)
+		rrsig.Algorithm = algorithm
+		return rrsig
+	}
+	ed448 := &dns.DNSKEY{Hdr: key.Hdr, Flags: key.Flags, Protocol: key.Protocol, Algorithm: dns.ED448, PublicKey: key.PublicKey}
+
+	tests := []struct {
+		name       string
+		keys       []dns.RR
+		signatures []*dns.RRSIG
+		status     model.DnssecStatus
+	}{
+		{"supported", []dns.RR{key}, []*dns.RRSIG{SignTestRRset(t, key, signer, rr)}, model.DnssecSecure},
+		{"unsupported", []dns.RR{ed448}, []*dns.RRSIG{unsupported(dns.ED448)}, model.DnssecInsecure},
+		{"unsupported and supported", []dns.RR{key, ed448}, []*dns.RRSIG{unsupported(dns.ED448), SignTestRRset(t, key, signer, rr)}, model.DnssecSecure},
+		{"supported key stripped", []dns.RR{key, ed448}, []*dns.RRSIG{unsupported(dns.ED448)}, model.DnssecBogus},
+		{"rejected", []dns.RR{ed448}, []*dns.RRSIG{unsupported(dns.RSAMD5)}, model.DnssecBogus},
+	}
+
+	for _, tt := range tests {
+
+		keys := model.NewDnsMsg(&dns.Msg{Answer: tt.keys})
+		rrset := model.DnsRRset{Name: "www.example.", Type: dns.TypeA, RR: []dns.RR{rr}, Signatures: tt.signatures}
+
+		result := NewDnssecResult(VerifyRRset(keys, rrset, time.Now()))
+
+		if result.Status != tt.status {
+			t.Fatalf("%s: expected %s, received %s", tt.name, tt.status, result)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestNsec3Iterations(t *testing.T) {
+
+	transverse.SetTest()
+
+	key, signer := NewTestZoneKey(t, "example.")
+	keys := model.NewDnsMsg(&dns.Msg{Answer: []dns.RR{key}})
+
+	names := map[string][]uint16{
+		"example.":   {dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"a.example.": {dns.TypeA},
+	}
+
+	tests := []struct {
+		name       string
+		iterations uint16
+		hash       uint8
+		signed     bool
+		status     model.DnssecStatus
+		ede        uint16
+	}{
+		{"no iteration", 0, dns.SHA1, true, model.DnssecSecure, 0},
+		{"limit", 100, dns.SHA1, true, model.DnssecSecure, 0},
+		{"insecure", 150, dns.SHA1, true, model.DnssecInsecure, edeUnsupportedNsec3Iterations},
+		{"bogus", 1000, dns.SHA1, true, model.DnssecBogus, edeUnsupportedNsec3Iterations},
+		{"forged", 150, dns.SHA1, false, model.DnssecBogus, dns.ExtendedErrorCodeRRSIGsMissing}, // signatures are verified first
+		{"unknown hash", 0, dns.SHA256, true, model.DnssecInsecure, 0},
+	}
+
+	for _, tt := range tests {
+
+		m := h.Msg("zzz.example.", dns.TypeA, dns.ClassINET)
+		m.Rcode = dns.RcodeNameError
+		for _, v := range NewTestNsec3Chain("example.", false, tt.iterations, names) {
+			v.Hash = tt.hash
+			m.Ns = append(m.Ns, v)
+			if tt.signed {
+				m.Ns = append(m.Ns, SignTestRRset(t, key, signer, v))
+			}
+		}
+
+		result := NewDnssecResult(VerifyDenial(model.NewDnsMsg(m), keys, time.Now()))
+
+		if result.Status != tt.status {
+			t.Fatalf("%s: expected %s, received %s: %v", tt.name, tt.status, result.Status, result.Ede)
+		}
+		if tt.ede != 0 && (result.Ede == nil || result.Ede.InfoCode != tt.ede) {
+			t.Fatalf("%s: expected extended error %d, received %v", tt.name, tt.ede, result.Ede)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResolverAlgorithms(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+
+	// a zone published with a DS of its key for each of the digests.
+	delegate := func(name string, digests ...uint8) {
+		zone := NewTestZone(t, zones.stub, name, nil)
+		ds := make([]dns.RR, 0, len(digests))
+		for _, digest := range digests {
+			v := zone.key.ToDS(dns.SHA256)
+			if digest != dns.GOST94 { // not implemented, only its type matters.
+				v = zone.key.ToDS(digest)
+			}
+			v.DigestType = digest
+			v.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+			ds = append(ds, v)
+		}
+		zones.stub.Add(name, dns.TypeDS, dns.RcodeSuccess, zones.root.Signed(t, ds...), nil)
+		zones.stub.Add("www."+name, dns.TypeA, dns.RcodeSuccess, zone.Signed(t,
+			MustRR(t, "www."+name+" 3600 IN A 127.0.0.1")), nil)
+	}
+
+	delegate("sha1.", dns.SHA1)
+	delegate("sha384.", dns.SHA256, dns.SHA384)
+	delegate("gost.", dns.GOST94)
+
+	// a stronger digest which does not match is not superseded by a weaker one.
+	downgrade := NewTestZone(t, zones.stub, "downgrade.", nil)
+	forged := downgrade.key.ToDS(dns.SHA384)
+	forged.Hdr = dns.RR_Header{Name: "downgrade.", Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+	forged.Digest = forged.Digest[2:] + "00"
+	weak := downgrade.key.ToDS(dns.SHA256)
+	weak.Hdr = forged.Hdr
+	zones.stub.Add("downgrade.", dns.TypeDS, dns.RcodeSuccess, zones.root.Signed(t, forged, weak), nil)
+	zones.stub.Add("www.downgrade.", dns.TypeA, dns.RcodeSuccess, downgrade.Signed(t,
+		MustRR(t, "www.downgrade. 3600 IN A 127.0.0.1")), nil)
+
+	tests := []struct {
+		name     string
+		expected model.DnssecStatus
+		ede      uint16
+	}{
+		{"www.example.", model.DnssecSecure, 0},
+		{"www.sha1.", model.DnssecSecure, 0},
+		{"www.sha384.", model.DnssecSecure, 0},
+		{"www.gost.", model.DnssecInsecure, dns.ExtendedErrorCodeUnsupportedDSDigestType},
+		{"www.downgrade.", model.DnssecBogus, dns.ExtendedErrorCodeDNSKEYMissing},
+	}
+
+	validator := zones.Validator()
+
+	for _, tt := range tests {
+		in, err := zones.stub.Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		r, _ := validator.Validate(in)
+		result := r.GetDnssecResult()
+		if result.Status != tt.expected {
+			t.Fatalf("%s: expected %s, received %s", tt.name, tt.expected, result)
+		}
+		if tt.ede != 0 && (result.Ede == nil || result.Ede.InfoCode != tt.ede) {
+			t.Fatalf("%s: expected EDE %d, received %s", tt.name, tt.ede, result)
+		}
+	}
+
+	t.Logf("Success !")
+}
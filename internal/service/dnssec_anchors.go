@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"golang-dns/internal/model"
+	"golang-dns/internal/service/conf"
+	t "golang-dns/internal/transverse"
+	"sync"
+)
+
+// DnssecTrustAnchors holds the digests of the root keys trusted by the validators.
+// It is initialized with the IANA bootstrap anchors, then updated by the RFC 5011 tracking.
+type DnssecTrustAnchors struct {
+	mu      *sync.RWMutex
+	digests *[]model.IanaKeyDigest
+}
+
+func NewDnssecTrustAnchors(anchors model.IanaAnchors) DnssecTrustAnchors {
+	var a DnssecTrustAnchors
+	defer t.Logger().Printf("%s initialized", &a)
+	a.mu = new(sync.RWMutex)
+	a.digests = &anchors.KeyDigest
+	return a
+}
+
+// NewDnssecBootstrapAnchors returns the trust anchors of the embedded IANA file.
+func NewDnssecBootstrapAnchors() DnssecTrustAnchors {
+	return NewDnssecTrustAnchors(LoadIanaFile(conf.IanaFile))
+}
+
+// KeyDigests returns the digests of the trusted keys.
+func (a DnssecTrustAnchors) KeyDigests() []model.IanaKeyDigest {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return *a.digests
+}
+
+// Set replaces the trusted keys, an empty set is ignored not to leave the validators without any anchor.
+func (a DnssecTrustAnchors) Set(digests []model.IanaKeyDigest) {
+	if len(digests) == 0 {
+		t.LoggerError().Printf("no trusted key left, keeping the current trust anchors")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	*a.digests = digests
+}
+
+func (a DnssecTrustAnchors) String() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	tags := make([]uint16, 0, len(*a.digests))
+	for _, d := range *a.digests {
+		tags = append(tags, d.KeyTag)
+	}
+	return fmt.Sprintf("DnssecTrustAnchors keyTags=%v", tags)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"strings"
+)
+
+// VerifyCnameChain checks that every RRset of the answer belongs to the chain of aliases
+// starting at the question name, through CNAME records and DNAME redirections.
+func VerifyCnameChain(q dns.Question, rrsets []model.DnsRRset) error {
+
+	onChain := make([]bool, len(rrsets))
+	name := q.Name
+
+	// every step of the chain consumes at least one RRset, this bounds the loops of aliases.
+	for step := 0; step <= len(rrsets); step++ {
+
+		next := ""
+
+		for i, rrset := range rrsets {
+
+			if rrset.Type == dns.TypeDNAME && dns.IsSubDomain(rrset.Name, name) && !sameName(rrset.Name, name) {
+				// a DNAME redirects the names below its owner
+				onChain[i] = true
+				if next == "" {
+					next = SynthesizeDname(name, rrset)
+				}
+				continue
+			}
+
+			if !sameName(rrset.Name, name) {
+				continue
+			}
+
+			switch rrset.Type {
+			case q.Qtype:
+				onChain[i] = true
+			case dns.TypeCNAME:
+				onChain[i] = true
+				next = rrset.RR[0].(*dns.CNAME).Target
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		name = next
+	}
+
+	for i, rrset := range rrsets {
+		if !onChain[i] {
+			return fmt.Errorf("RRset is not part of the CNAME chain of %s: %s", q.Name, rrset)
+		}
+	}
+
+	return nil
+}
+
+// IsSynthesizedCname tells if the RRset is a CNAME synthesized from a signed DNAME of the answer (RFC 6672 §3).
+func IsSynthesizedCname(rrset model.DnsRRset, rrsets []model.DnsRRset) bool {
+
+	if rrset.Type != dns.TypeCNAME {
+		return false
+	}
+
+	target := rrset.RR[0].(*dns.CNAME).Target
+
+	for _, v := range rrsets {
+		if v.Type == dns.TypeDNAME && len(v.Signatures) > 0 && dns.IsSubDomain(v.Name, rrset.Name) && !sameName(v.Name, rrset.Name) {
+			if sameName(SynthesizeDname(rrset.Name, v), target) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SynthesizeDname substitutes the owner of the DNAME by its target in name.
+func SynthesizeDname(name string, dname model.DnsRRset) string {
+	prefix := strings.TrimSuffix(strings.ToLower(name), strings.ToLower(dname.Name))
+	return prefix + dname.RR[0].(*dns.DNAME).Target
+}
+
+func sameName(a, b string) bool {
+	return h.CanonicalCompare(a, b) == 0
+}
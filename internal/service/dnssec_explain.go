@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"golang-dns/internal/model"
+	"strings"
+	"time"
+)
+
+// DnssecTracer follows the steps of a validation, as they are walked by DnssecRecursion.
+type DnssecTracer interface {
+	// TraceZone is called once the keys of a zone have been validated against its DS records in the parent zone, or not.
+	TraceZone(zone string, dnsKeyResp, dsResp, parentDnsKeyResp model.DnsMsg, err error)
+	// TraceFinal is called once the records of the response have been verified with the keys of a zone, or not.
+	TraceFinal(keys model.DnsMsg, err error)
+}
+
+// WithTracer returns the validator reporting the steps of its validations to tracer.
+func (s DnssecValidator) WithTracer(tracer DnssecTracer) DnssecValidator {
+	s.tracer = tracer
+	return s
+}
+
+// Explain validates the response the way the resolver does, with the validation mode of its policy,
+// and describes every zone crossed: its keys, the DS records matching them, the validity windows of the signatures,
+// the records denying a DS, then the verification of the response and the step at which the validation failed.
+// The trust cache is bypassed, so that the whole chain of trust is walked, and the aggressive cache is left untouched.
+func (s DnssecValidator) Explain(rm model.DnsMsg) model.DnssecExplanation {
+
+	q := rm.GetQuestion()
+	e := model.DnssecExplanation{Question: fmt.Sprintf("%s %s", q.Name, dns.TypeToString[q.Qtype])}
+
+	explainer := &dnssecExplainer{e: &e, rm: rm, anchors: s.anchors, now: s.Now(), explained: make(map[string]bool)}
+
+	validator := s.WithTracer(explainer)
+	validator.trust = NewDnssecTrustCache(NewLru(DefaultTrustCacheSize))
+	validator.aggressive = DnssecAggressiveCache{}
+
+	result, err := validator.ValidateWithPolicy(rm, model.DnssecValidateIfSigned)
+	e.Result = result.GetDnssecResult().String()
+
+	// the response failed to verify with the keys of the deepest zone, whose signatures tell why.
+	if err != nil && e.Failed == "" {
+		e.Error = err.Error()
+		e.Failed = "response"
+		if explainer.failed != "" {
+			e.Failed = "response signed by zone " + explainer.failed
+			explainer.explainResponse(explainer.failedKeys, explainer.failed)
+		}
+	}
+
+	return e
+}
+
+// dnssecExplainer is the DnssecTracer building the explanation of a validation.
+type dnssecExplainer struct {
+	e          *model.DnssecExplanation
+	rm         model.DnsMsg
+	anchors    DnssecTrustAnchors
+	now        time.Time
+	explained  map[string]bool // zones whose signatures of the response are explained.
+	failed     string          // last zone whose keys did not verify the response.
+	failedKeys model.DnsMsg
+}
+
+func (x *dnssecExplainer) TraceZone(zone string, dnsKeyResp, dsResp, parentDnsKeyResp model.DnsMsg, err error) {
+
+	z := model.DnssecZoneExplanation{Zone: zone}
+
+	if dnsKeyResp.GetMsg() != nil && dsResp.GetMsg() != nil {
+		x.explainZone(&z, dnsKeyResp, dsResp, parentDnsKeyResp)
+	}
+
+	switch {
+	case errors.Is(err, ErrInsecureDelegation):
+		z.Status = "insecure delegation"
+	case err != nil:
+		z.Status = "failed"
+		z.Error = err.Error()
+		x.e.Failed = "zone " + zone
+		x.e.Error = err.Error()
+	case zone != "." && dsResp.IsEmpty():
+		z.Status = "not a zone cut"
+	default:
+		z.Status = "secure"
+	}
+
+	x.e.Zones = append(x.e.Zones, z)
+}
+
+func (x *dnssecExplainer) TraceFinal(keys model.DnsMsg, err error) {
+
+	zone := ""
+	if dnsKeys := keys.GetDNSKEY(); len(dnsKeys) > 0 {
+		zone = strings.ToLower(dnsKeys[0].Hdr.Name)
+	}
+
+	if err != nil {
+		x.failed, x.failedKeys = zone, keys
+		return
+	}
+
+	x.explainResponse(keys, zone)
+}
+
+// explainResponse describes the signatures of the response made by the zone, and the records of a denial.
+func (x *dnssecExplainer) explainResponse(keys model.DnsMsg, zone string) {
+
+	if x.explained[zone] {
+		return
+	}
+	x.explained[zone] = true
+
+	if x.rm.IsNegative() {
+		x.e.Signatures = append(x.e.Signatures, explainSignatures(keys, x.rm, "", x.now)...)
+		x.e.Denial = explainDenial(x.rm)
+		return
+	}
+
+	x.e.Signatures = append(x.e.Signatures, explainSignatures(keys, x.rm, zone, x.now)...)
+}
+
+// explainZone describes the keys of the zone, the DS records or trust anchors they are matched with, and their signatures.
+func (x *dnssecExplainer) explainZone(z *model.DnssecZoneExplanation, dnsKeyResp, dsResp, parentKeys model.DnsMsg) {
+
+	keys := dnsKeyResp.GetDNSKEY()
+	for _, k := range keys {
+		z.Keys = append(z.Keys, model.DnssecKeyExplanation{KeyTag: k.KeyTag(), Algorithm: algorithmName(k.Algorithm), Flags: k.Flags})
+	}
+
+	matches := func(ds *dns.DS) bool {
+		for _, k := range keys {
+			if VerifyDigest(k, ds) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if z.Zone == "." {
+		for _, a := range x.anchors.KeyDigests() {
+			ds := a.ToDS()
+			z.DS = append(z.DS, model.DnssecDSExplanation{KeyTag: ds.KeyTag, Algorithm: algorithmName(ds.Algorithm),
+				DigestType: digestName(ds.DigestType), Anchor: true, Matches: matches(ds)})
+		}
+		z.Signatures = explainSignatures(dnsKeyResp, dnsKeyResp, "", x.now)
+		return
+	}
+
+	for _, ds := range dsResp.GetDS() {
+		z.DS = append(z.DS, model.DnssecDSExplanation{KeyTag: ds.KeyTag, Algorithm: algorithmName(ds.Algorithm),
+			DigestType: digestName(ds.DigestType), Matches: matches(ds)})
+	}
+
+	z.Signatures = explainSignatures(dnsKeyResp, dnsKeyResp, "", x.now)
+	if parentKeys.GetMsg() != nil {
+		z.Signatures = append(z.Signatures, explainSignatures(parentKeys, dsResp, "", x.now)...)
+	}
+
+	if dsResp.IsEmpty() {
+		z.Denial = explainDenial(dsResp)
+	}
+}
+
+// explainSignatures verifies every signature of the answer and authority sections made by signer, by any zone when empty.
+func explainSignatures(keys model.DnsMsg, m model.DnsMsg, signer string, now time.Time) []model.DnssecSigExplanation {
+
+	signatures := make([]model.DnssecSigExplanation, 0)
+
+	for _, section := range [][]dns.RR{m.GetMsg().Answer, m.GetMsg().Ns} {
+		for _, rrset := range model.NewDnsRRsets(section) {
+			for _, rrsig := range rrset.Signatures {
+
+				if signer != "" && !strings.EqualFold(rrsig.SignerName, signer) {
+					continue
+				}
+
+				s := model.DnssecSigExplanation{
+					RRset:      rrset.String(),
+					KeyTag:     rrsig.KeyTag,
+					Algorithm:  algorithmName(rrsig.Algorithm),
+					Signer:     rrsig.SignerName,
+					Inception:  time.Unix(int64(rrsig.Inception), 0),
+					Expiration: time.Unix(int64(rrsig.Expiration), 0),
+				}
+				if err := VerifySig(keys.ByKeyTag(rrsig.KeyTag), rrsig, rrset.RR, now); err != nil {
+					s.Error = err.Error()
+				}
+
+				signatures = append(signatures, s)
+			}
+		}
+	}
+
+	return signatures
+}
+
+func explainDenial(m model.DnsMsg) []string {
+	denial := make([]string, 0)
+	for _, rr := range m.GetMsg().Ns {
+		if rrtype := rr.Header().Rrtype; rrtype == dns.TypeNSEC || rrtype == dns.TypeNSEC3 {
+			denial = append(denial, rr.String())
+		}
+	}
+	return denial
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"golang-dns/internal/transverse"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDnssecExplain(t *testing.T) {
+
+	transverse.SetTest()
+
+	tests := []struct {
+		name    string
+		dnsType uint16
+		now     time.Time
+		tamper  func(m *dns.Msg)
+		zones   int
+		failed  string
+	}{
+		{"client.dropbox.com.", dns.TypeA, DnssecFixturesTime, nil, 4, ""},
+		{"nxdomain.afnic.fr.", dns.TypeA, DnssecFixturesTime, nil, 3, ""},
+		{"nxdomain.insecure.fr.", dns.TypeA, DnssecFixturesTime, nil, 3, ""},
+		{"afnic.fr.", dns.TypeA, DnssecFixturesTime, func(m *dns.Msg) { m.Answer[0].(*dns.A).A = net.IPv4(127, 0, 0, 1) }, 3, "response signed by zone afnic.fr."},
+		{"afnic.fr.", dns.TypeA, DnssecFixturesExpiration.Add(time.Hour), nil, 1, "zone ."},
+	}
+
+	proxy := NewDnssecFixtureResolver(t)
+	resolver := proxy.AsResolver()
+
+	for _, tt := range tests {
+
+		validator := NewDnssecValidatorFromIanaFile(proxy, LoadIanaFile(DnssecFixturesAnchorsFile)).WithClock(transverse.FixedClock(tt.now))
+
+		r, err := resolver.Query(tt.name, tt.dnsType)
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		if tt.tamper != nil {
+			tt.tamper(r.GetMsg())
+		}
+
+		// the trust cache warmed up by a previous validation does not hide any zone.
+		validated, _ := validator.Validate(r)
+
+		e := validator.Explain(r)
+		if e.Result != validated.GetDnssecResult().String() {
+			t.Fatalf("%s: expect result %s, got %s", tt.name, validated.GetDnssecResult(), e.Result)
+		}
+		if e.Failed != tt.failed {
+			t.Fatalf("%s: expect failure at %q, got %q: %s", tt.name, tt.failed, e.Failed, e.Error)
+		}
+		if len(e.Zones) != tt.zones {
+			t.Fatalf("%s: expect %d zones, got %d", tt.name, tt.zones, len(e.Zones))
+		}
+		if tt.failed != "" && e.Error == "" {
+			t.Fatalf("%s: the failure is not explained", tt.name)
+		}
+
+		t.Logf("%s", e)
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	_ "embed"
+	"flag"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The synthetic zones of testdata/dnssec are regenerated with:
+//
+//	go test ./internal/service -run TestGenerateDnssecFixtures -generate
+var generateFixtures = flag.Bool("generate", false, "sign the synthetic zones and record the fixtures of testdata/dnssec")
+
+const (
+	DnssecFixturesPath        = "testdata/dnssec/fixtures.json"
+	DnssecFixturesAnchorsPath = "testdata/dnssec/anchors.xml"
+)
+
+var (
+	// DnssecFixturesTime is the time the fixtures are validated at, their signatures are valid for a month around it.
+	DnssecFixturesTime       = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	DnssecFixturesInception  = DnssecFixturesTime.Add(-24 * time.Hour)
+	DnssecFixturesExpiration = DnssecFixturesTime.Add(30 * 24 * time.Hour)
+)
+
+//go:embed testdata/dnssec/fixtures.json
+var DnssecFixturesFile []byte
+
+//go:embed testdata/dnssec/anchors.xml
+var DnssecFixturesAnchorsFile string
+
+// DnssecFixtureQuestions are the questions answered by the fixtures, along with the result of their validation.
+var DnssecFixtureQuestions = []struct {
+	name    string
+	dnsType uint16
+	status  model.DnssecStatus
+}{
+	{"afnic.fr.", dns.TypeSOA, model.DnssecSecure},
+	{"afnic.fr.", dns.TypeA, model.DnssecSecure},
+	{"afnic.fr.", dns.TypeMX, model.DnssecSecure},
+	{"afnic.fr.", dns.TypeTXT, model.DnssecSecure},
+	{"afnic.fr.", dns.TypeAAAA, model.DnssecSecure},       // NODATA
+	{"nxdomain.afnic.fr.", dns.TypeA, model.DnssecSecure}, // NXDOMAIN
+	{"icourrier.fr.", dns.TypeMX, model.DnssecSecure},
+	{"_dmarc.icourrier.fr.", dns.TypeTXT, model.DnssecSecure},
+	{"chrome.cloudflare-dns.com.", dns.TypeA, model.DnssecSecure}, // not a zone cut
+	{"protonvpn.ch.", dns.TypeA, model.DnssecSecure},
+	{"client.dropbox.com.", dns.TypeA, model.DnssecSecure}, // CNAME to another zone
+	{"api.dropboxapi.com.", dns.TypeA, model.DnssecSecure},
+	{"dns.google.", dns.TypeA, model.DnssecSecure},
+	{"cloudflare-dns.com.", dns.TypeA, model.DnssecSecure},
+	{"quad9.net.", dns.TypeA, model.DnssecSecure},
+	{"paypal.com.", dns.TypeA, model.DnssecSecure},
+	{"dns.quad9.net.", dns.TypeA, model.DnssecSecure},
+	{"nxdomain.insecure.fr.", dns.TypeA, model.DnssecInsecure}, // unsigned delegation
+	{"www.afnic.fr.", dns.TypeA, model.DnssecInsecure},         // CNAME to an unsigned delegation
+}
+
+// NewTestAuthorityZones builds the synthetic zones of the fixtures.
+func NewTestAuthorityZones(t *testing.T) *TestAuthority {
+
+	a := NewTestAuthority(t, DnssecFixturesInception, DnssecFixturesExpiration)
+
+	for _, zone := range []string{"fr.", "afnic.fr.", "icourrier.fr.", "ch.", "protonvpn.ch.", "com.", "cloudflare-dns.com.",
+		"dropbox.com.", "dropbox-dns.com.", "dropboxapi.com.", "paypal.com.", "google.", "dns.google.", "net.", "quad9.net."} {
+		a.AddZone(zone, true)
+	}
+	a.AddZone("insecure.fr.", false)
+
+	a.AddRR(
+		"afnic.fr. 3600 IN A 192.134.0.49",
+		"afnic.fr. 3600 IN MX 10 mx1.nic.fr.",
+		"afnic.fr. 3600 IN TXT \"v=spf1 mx -all\"",
+		"www.afnic.fr. 3600 IN CNAME www.insecure.fr.",
+		"icourrier.fr. 3600 IN MX 10 mx.icourrier.fr.",
+		"_dmarc.icourrier.fr. 3600 IN TXT \"v=DMARC1; p=reject\"",
+		"cloudflare-dns.com. 300 IN A 104.16.248.249",
+		"chrome.cloudflare-dns.com. 300 IN A 104.18.26.211",
+		"protonvpn.ch. 300 IN A 185.159.159.140",
+		"client.dropbox.com. 300 IN CNAME client.dropbox-dns.com.",
+		"client.dropbox-dns.com. 60 IN A 162.125.21.3",
+		"api.dropboxapi.com. 60 IN A 162.125.4.19",
+		"paypal.com. 300 IN A 151.101.3.1",
+		"dns.google. 900 IN A 8.8.8.8",
+		"quad9.net. 3600 IN A 216.21.3.77",
+		"dns.quad9.net. 3600 IN A 9.9.9.9",
+		"www.insecure.fr. 3600 IN A 127.0.0.1",
+	)
+
+	return a
+}
+
+// TestAuthority is the authoritative server of a tree of synthetic zones signed with test keys,
+// it answers every question with the signed records, or with the signed NSEC records proving their absence.
+type TestAuthority struct {
+	DnsResolverProxyBase
+	t          *testing.T
+	zones      map[string]*TestAuthorityZone
+	inception  time.Time
+	expiration time.Time
+}
+
+// TestAuthorityZone holds the records of a zone by owner name, its delegations included.
+// An unsigned zone has no key.
+type TestAuthorityZone struct {
+	TestZone
+	apex    string
+	records map[string][]dns.RR
+}
+
+func NewTestAuthority(t *testing.T, inception, expiration time.Time) *TestAuthority {
+	var a TestAuthority
+	a.initDnsResolverBase(&a)
+	a.t = t
+	a.zones = make(map[string]*TestAuthorityZone)
+	a.inception = inception
+	a.expiration = expiration
+	a.AddZone(".", true)
+	return &a
+}
+
+// Root returns the zone whose key is the trust anchor.
+func (a TestAuthority) Root() TestZone {
+	return a.zones["."].TestZone
+}
+
+// AddZone creates a zone and its delegation from the parent zone, signed with a generated key when signed.
+func (a TestAuthority) AddZone(name string, signed bool) {
+
+	z := &TestAuthorityZone{apex: name, records: make(map[string][]dns.RR)}
+	z.add(MustRR(a.t, name+" 3600 IN SOA ns."+strings.TrimPrefix(name, ".")+" admin."+strings.TrimPrefix(name, ".")+" 1 7200 3600 1209600 3600"))
+	if signed {
+		z.key, z.signer = NewTestZoneKey(a.t, name)
+		z.add(z.key)
+	}
+
+	if name != "." {
+		parent := a.zoneOf(h.SubZone(name, dns.CountLabel(name)-1))
+		parent.add(MustRR(a.t, name+" 3600 IN NS ns."+name))
+		if signed {
+			ds := z.key.ToDS(dns.SHA256)
+			ds.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+			parent.add(ds)
+		}
+	}
+
+	a.zones[name] = z
+}
+
+// AddRR adds the records to the zones they belong to.
+func (a TestAuthority) AddRR(records ...string) {
+	for _, s := range records {
+		rr := MustRR(a.t, s)
+		a.zoneOf(rr.Header().Name).add(rr)
+	}
+}
+
+// zoneOf returns the deepest zone of name.
+func (a TestAuthority) zoneOf(name string) *TestAuthorityZone {
+	name = strings.ToLower(name)
+	for deep := dns.CountLabel(name); deep >= 0; deep-- {
+		if z, found := a.zones[h.SubZone(name, deep)]; found {
+			return z
+		}
+	}
+	a.t.Fatalf("no zone for %s", name)
+	return nil
+}
+
+func (a TestAuthority) Proxy(rm model.DnsMsg) (model.DnsMsg, error) {
+
+	q := rm.GetQuestion()
+	name := strings.ToLower(q.Name)
+
+	// the DS of a zone is served by its parent.
+	z := a.zoneOf(name)
+	if q.Qtype == dns.TypeDS && name == z.apex && name != "." {
+		z = a.zoneOf(h.SubZone(name, dns.CountLabel(name)-1))
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(rm.GetMsg())
+
+	if rrset := z.rrset(name, q.Qtype); len(rrset) > 0 {
+		m.Answer = a.sign(z, rrset...)
+		return model.NewDnsMsg(m), nil
+	}
+
+	// the answer of a CNAME is followed by the answer of its target.
+	if cname := z.rrset(name, dns.TypeCNAME); len(cname) > 0 {
+		m.Answer = a.sign(z, cname...)
+		target, err := a.Proxy(model.NewDnsMsg(h.Msg(cname[0].(*dns.CNAME).Target, q.Qtype, dns.ClassINET)))
+		if err != nil {
+			return rm, err
+		}
+		m.Answer = append(m.Answer, target.GetMsg().Answer...)
+		return model.NewDnsMsg(m), nil
+	}
+
+	m.Ns = a.sign(z, z.rrset(z.apex, dns.TypeSOA)...)
+
+	if _, exists := z.records[name]; exists {
+		m.Ns = append(m.Ns, a.sign(z, z.nsec(name))...)
+		return model.NewDnsMsg(m), nil
+	}
+
+	// the closest encloser exists, neither the name nor the wildcard of the encloser do.
+	m.Rcode = dns.RcodeNameError
+	encloser := name
+	for _, exists := z.records[encloser]; !exists; _, exists = z.records[encloser] {
+		encloser = h.SubZone(encloser, dns.CountLabel(encloser)-1)
+	}
+	cover := z.covering(name)
+	m.Ns = append(m.Ns, a.sign(z, cover)...)
+	if wildcard := z.covering(dns.Fqdn("*." + strings.TrimSuffix(encloser, "."))); wildcard.Hdr.Name != cover.Hdr.Name {
+		m.Ns = append(m.Ns, a.sign(z, wildcard)...)
+	}
+
+	return model.NewDnsMsg(m), nil
+}
+
+// sign returns the RRset followed by its signature, when the zone is signed.
+func (a TestAuthority) sign(z *TestAuthorityZone, rrset ...dns.RR) []dns.RR {
+	if z.key == nil || len(rrset) == 0 {
+		return rrset
+	}
+	return append(rrset, SignTestRRsetPeriod(a.t, z.key, z.signer, a.inception, a.expiration, rrset...))
+}
+
+func (_ TestAuthority) String() string {
+	return "TestAuthority"
+}
+
+func (z *TestAuthorityZone) add(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	z.records[name] = append(z.records[name], rr)
+}
+
+func (z *TestAuthorityZone) rrset(name string, dnsType uint16) []dns.RR {
+	return h.CollectAll(z.records[name], dnsType)
+}
+
+// names returns the owner names of the zone in canonical order.
+func (z *TestAuthorityZone) names() []string {
+	names := make([]string, 0, len(z.records))
+	for name := range z.records {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return h.CanonicalCompare(names[i], names[j]) < 0
+	})
+	return names
+}
+
+// nsec returns the NSEC record of an owner name of the zone.
+func (z *TestAuthorityZone) nsec(name string) *dns.NSEC {
+
+	names := z.names()
+	i := sort.Search(len(names), func(i int) bool {
+		return h.CanonicalCompare(names[i], name) >= 0
+	})
+
+	types := []uint16{dns.TypeNSEC, dns.TypeRRSIG}
+	for _, rr := range z.records[name] {
+		types = append(types, rr.Header().Rrtype)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	bitmap := make([]uint16, 0, len(types))
+	for _, v := range types {
+		if len(bitmap) == 0 || bitmap[len(bitmap)-1] != v {
+			bitmap = append(bitmap, v)
+		}
+	}
+
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+		NextDomain: names[(i+1)%len(names)],
+		TypeBitMap: bitmap,
+	}
+}
+
+// covering returns the NSEC record whose owner is the last name of the zone sorted before name.
+func (z *TestAuthorityZone) covering(name string) *dns.NSEC {
+	names := z.names()
+	i := sort.Search(len(names), func(i int) bool {
+		return h.CanonicalCompare(names[i], name) >= 0
+	})
+	return z.nsec(names[i-1])
+}
+
+// TestGenerateDnssecFixtures signs the synthetic zones, records the responses needed to validate the questions of the fixtures,
+// and saves them along with the trust anchor of the synthetic root.
+func TestGenerateDnssecFixtures(t *testing.T) {
+
+	if !*generateFixtures {
+		t.Skip("fixtures are generated with -generate")
+	}
+
+	transverse.SetTest()
+
+	authority := NewTestAuthorityZones(t)
+	recorder := NewDnsResolverRecorder(authority)
+	validator := NewDnssecValidatorFromIanaFile(recorder, authority.Root().Anchors()).WithClock(transverse.FixedClock(DnssecFixturesTime))
+	resolver := recorder.AsResolver()
+
+	for _, tt := range DnssecFixtureQuestions {
+		r, err := resolver.Query(tt.name, tt.dnsType)
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		r, _ = validator.Validate(r)
+		if r.GetDnssecResult().Status != tt.status {
+			t.Fatalf("%s %s: expect %s, got %s", tt.name, dns.TypeToString[tt.dnsType], tt.status, r.GetDnssecResult())
+		}
+	}
+
+	fixtures, err := recorder.Fixtures().AsBytes()
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if err := os.WriteFile(DnssecFixturesPath, fixtures, 0644); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	anchors := authority.Root().Anchors()
+	anchors.KeyDigest[0].ValidFrom = DnssecFixturesInception
+	b, err := SaveIanaFile(anchors)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if err := os.WriteFile(DnssecFixturesAnchorsPath, b, 0644); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	t.Logf("Success !")
+}
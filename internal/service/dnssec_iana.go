@@ -3,8 +3,8 @@ package service
 import (
 	"encoding/xml"
 	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
 	"log"
-	"time"
 )
 
 func LoadIanaFile(content string) model.IanaAnchors {
@@ -15,14 +15,14 @@ func LoadIanaFile(content string) model.IanaAnchors {
 		log.Fatal(err)
 	}
 
-	anchor.KeyDigest = anchor.KeyDigests(time.Now())
+	anchor.KeyDigest = anchor.KeyDigests(t.Now())
 
 	return anchor
 }
 
 // SaveIanaFile applies a time filter to existing keys and save it again to its binary form
 func SaveIanaFile(anchor model.IanaAnchors) ([]byte, error) {
-	anchor.KeyDigest = anchor.KeyDigests(time.Now())
+	anchor.KeyDigest = anchor.KeyDigests(t.Now())
 	b, err := xml.Marshal(anchor)
 	return b, err
 }
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNtaLifetime = 24 * time.Hour
+	MaxNtaLifetime     = 7 * 24 * time.Hour // negative trust anchors must not stay forgotten (RFC 7646 §2.1).
+)
+
+// DnssecPolicy holds the validation mode of domains, the rule of the closest enclosing domain applies.
+// Rules can be changed at runtime, expired ones are dropped.
+// The mode is the one of the name of the question: the zones the answer crosses, ex: the target of a CNAME, follow it.
+type DnssecPolicy struct {
+	mu    *sync.RWMutex
+	rules map[string]model.DnssecPolicyRule
+}
+
+func NewDnssecPolicy(rules ...model.DnssecPolicyRule) (DnssecPolicy, error) {
+	var p DnssecPolicy
+	defer t.Logger().Printf("%s initialized", &p)
+	p.mu = new(sync.RWMutex)
+	p.rules = make(map[string]model.DnssecPolicyRule)
+	for _, r := range rules {
+		if err := p.Set(r); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// ParseDnssecPolicy parses permanent rules formatted as domain=mode, separated by commas.
+func ParseDnssecPolicy(s string) ([]model.DnssecPolicyRule, error) {
+
+	rules := make([]model.DnssecPolicyRule, 0)
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		domain, mode, found := strings.Cut(item, "=")
+		if !found {
+			return rules, fmt.Errorf("invalid dnssec policy rule, expected domain=mode: %s", item)
+		}
+
+		m, err := model.ParseDnssecMode(mode)
+		if err != nil {
+			return rules, err
+		}
+
+		rules = append(rules, model.DnssecPolicyRule{Domain: domain, Mode: m})
+	}
+
+	return rules, nil
+}
+
+// NewNegativeTrustAnchor returns a rule skipping the validation of domain during lifetime.
+func NewNegativeTrustAnchor(domain string, lifetime time.Duration, reason string) (model.DnssecPolicyRule, error) {
+	if lifetime <= 0 || lifetime > MaxNtaLifetime {
+		return model.DnssecPolicyRule{}, fmt.Errorf("negative trust anchor lifetime must be within 0 and %s: %s", MaxNtaLifetime, lifetime)
+	}
+	expires := t.Now().Add(lifetime)
+	return model.DnssecPolicyRule{Domain: domain, Mode: model.DnssecSkip, Expires: &expires, Reason: reason}, nil
+}
+
+// Set adds or replaces the rule of a domain.
+func (p DnssecPolicy) Set(r model.DnssecPolicyRule) error {
+
+	if _, valid := dns.IsDomainName(r.Domain); !valid || r.Domain == "" {
+		return fmt.Errorf("invalid dnssec policy domain: %s", r.Domain)
+	}
+	if _, err := model.ParseDnssecMode(string(r.Mode)); err != nil {
+		return err
+	}
+
+	r.Domain = strings.ToLower(dns.Fqdn(r.Domain))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules[r.Domain] = r
+
+	t.Logger().Printf("dnssec %s set", r)
+
+	return nil
+}
+
+// Delete removes the rule of a domain, it tells whether there was one.
+func (p DnssecPolicy) Delete(domain string) bool {
+
+	domain = strings.ToLower(dns.Fqdn(domain))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, found := p.rules[domain]
+	if found {
+		delete(p.rules, domain)
+		t.Logger().Printf("dnssec %s deleted", r)
+	}
+
+	return found
+}
+
+// Rules returns the active rules, sorted by domain.
+func (p DnssecPolicy) Rules() []model.DnssecPolicyRule {
+
+	p.expire(t.Now())
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rules := make([]model.DnssecPolicyRule, 0, len(p.rules))
+	for _, r := range p.rules {
+		rules = append(rules, r)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return h.CanonicalCompare(rules[i].Domain, rules[j].Domain) < 0
+	})
+
+	return rules
+}
+
+// Lookup returns the rule of the closest enclosing domain of name.
+func (p DnssecPolicy) Lookup(name string) (model.DnssecPolicyRule, bool) {
+
+	now := t.Now()
+	name = strings.ToLower(dns.Fqdn(name))
+
+	rule, found, expired := p.lookup(name, now)
+
+	// the expiration of a negative trust anchor is logged as soon as it is noticed.
+	if expired {
+		p.expire(now)
+	}
+
+	return rule, found
+}
+
+func (p DnssecPolicy) lookup(name string, now time.Time) (model.DnssecPolicyRule, bool, bool) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	expired := false
+	for deep := dns.CountLabel(name); deep >= 0 && len(p.rules) > 0; deep-- {
+		r, found := p.rules[h.SubZone(name, deep)]
+		if found && r.IsExpired(now) {
+			expired = true
+			continue
+		}
+		if found {
+			return r, true, expired
+		}
+	}
+
+	return model.DnssecPolicyRule{}, false, expired
+}
+
+// Mode returns the validation mode of name, def when no rule applies.
+func (p DnssecPolicy) Mode(name string, def model.DnssecMode) model.DnssecMode {
+	if r, found := p.Lookup(name); found {
+		return r.Mode
+	}
+	return def
+}
+
+// expire drops the expired rules.
+func (p DnssecPolicy) expire(now time.Time) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for domain, r := range p.rules {
+		if r.IsExpired(now) {
+			delete(p.rules, domain)
+			t.Logger().Printf("dnssec %s expired", r)
+		}
+	}
+}
+
+func (p DnssecPolicy) String() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return fmt.Sprintf("DnssecPolicy rules=%d", len(p.rules))
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"golang-dns/internal/transverse"
+	"testing"
+	"time"
+)
+
+func TestDnssecPolicy(t *testing.T) {
+
+	rules, err := ParseDnssecPolicy("example.com=skip, corp.example.com.=enforce")
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	policy, err := NewDnssecPolicy(rules...)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	nta, err := NewNegativeTrustAnchor("Broken.org", time.Hour, "botched rollover")
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if err := policy.Set(nta); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	expired := time.Now().Add(-time.Second)
+	if err := policy.Set(model.DnssecPolicyRule{Domain: "old.org", Mode: model.DnssecSkip, Expires: &expired}); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		expected model.DnssecMode
+	}{
+		{"example.com.", model.DnssecSkip},
+		{"www.example.com.", model.DnssecSkip},
+		{"www.corp.example.com.", model.DnssecEnforce},
+		{"WWW.BROKEN.ORG.", model.DnssecSkip},
+		{"www.old.org.", model.DnssecValidateIfSigned},
+		{"notexample.com.", model.DnssecValidateIfSigned},
+		{"com.", model.DnssecValidateIfSigned},
+	}
+
+	for _, tt := range tests {
+		if mode := policy.Mode(tt.name, model.DnssecValidateIfSigned); mode != tt.expected {
+			t.Fatalf("%s: expected %s, received %s", tt.name, tt.expected, mode)
+		}
+	}
+
+	if len(policy.Rules()) != 3 {
+		t.Fatalf("expected the expired rule to be dropped, received %v", policy.Rules())
+	}
+
+	// the negative trust anchors expire with the shared clock, ex: synchronized with Roughtime servers.
+	transverse.SetClock(transverse.FixedClock(time.Now().Add(2 * time.Hour)))
+	mode := policy.Mode("www.broken.org.", model.DnssecValidateIfSigned)
+	transverse.SetClock(transverse.SystemClock{})
+	if mode != model.DnssecValidateIfSigned {
+		t.Fatalf("expected the negative trust anchor to expire with the shared clock, received %s", mode)
+	}
+	if len(policy.Rules()) != 2 {
+		t.Fatalf("expected the negative trust anchor to be dropped, received %v", policy.Rules())
+	}
+
+	if !policy.Delete("example.com") || policy.Mode("www.example.com.", model.DnssecEnforce) != model.DnssecEnforce {
+		t.Fatalf("expected the rule to be deleted")
+	}
+
+	for _, invalid := range []string{"example.com", "example.com=off"} {
+		if _, err := ParseDnssecPolicy(invalid); err == nil {
+			t.Fatalf("%s: not received any error", invalid)
+		}
+	}
+
+	if _, err := NewNegativeTrustAnchor("example.com", MaxNtaLifetime+time.Hour, ""); err == nil {
+		t.Fatalf("expected a negative trust anchor lifetime to be bounded")
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecResolverPolicy(t *testing.T) {
+
+	transverse.SetTest()
+
+	zones := NewTestZones(t)
+
+	// an answer which does not validate, an unsigned one of a signed zone, and one of an insecure zone.
+	zones.stub.Add("bogus.example.", dns.TypeA, dns.RcodeSuccess, zones.other.Signed(t,
+		MustRR(t, "bogus.example. 3600 IN A 127.0.0.1")), nil)
+	zones.stub.Add("unsigned.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+		MustRR(t, "unsigned.example. 3600 IN A 127.0.0.1")}, nil)
+	zones.stub.Add("www.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+		MustRR(t, "www.insecure. 3600 IN A 127.0.0.1")}, nil)
+
+	policy, _ := NewDnssecPolicy()
+	validator := zones.Validator().WithPolicy(policy)
+	enforced := NewDnssecResolverEnforced(zones.stub, validator)
+	resolvers := []DnsResolverProxy{NewDnssecResolver(zones.stub, validator), &enforced}
+
+	tests := []struct {
+		name  string
+		rule  *model.DnssecPolicyRule
+		valid []bool // expected by each of the resolvers.
+	}{
+		{"bogus.example.", nil, []bool{false, false}},
+		{"unsigned.example.", nil, []bool{false, false}},
+		{"www.insecure.", nil, []bool{true, false}},
+		{"bogus.example.", &model.DnssecPolicyRule{Domain: "example.", Mode: model.DnssecSkip}, []bool{true, true}},
+		{"unsigned.example.", &model.DnssecPolicyRule{Domain: "unsigned.example.", Mode: model.DnssecSkip}, []bool{true, true}},
+		{"www.insecure.", &model.DnssecPolicyRule{Domain: "insecure.", Mode: model.DnssecValidateIfSigned}, []bool{true, true}},
+		{"unsigned.example.", &model.DnssecPolicyRule{Domain: "example.", Mode: model.DnssecEnforce}, []bool{false, false}},
+		{"www.example.", &model.DnssecPolicyRule{Domain: "example.", Mode: model.DnssecEnforce}, []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+
+		policy.Delete("example.")
+		policy.Delete("unsigned.example.")
+		policy.Delete("insecure.")
+		if tt.rule != nil {
+			if err := policy.Set(*tt.rule); err != nil {
+				t.Fatalf("received error: %v", err.Error())
+			}
+		}
+
+		for i, resolver := range resolvers {
+			_, err := resolver.Proxy(model.NewDnsMsg(h.Msg(tt.name, dns.TypeA, dns.ClassINET)))
+			if tt.valid[i] && err != nil {
+				t.Fatalf("%s %v %s: received error: %v", tt.name, tt.rule, resolver, err.Error())
+			}
+			if !tt.valid[i] && err == nil {
+				t.Fatalf("%s %v %s: not received any error", tt.name, tt.rule, resolver)
+			}
+		}
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"golang-dns/internal/model"
+)
+
+const (
+	maxEdeText = 200 // bounds the size of the explanation sent to the clients.
+)
+
+// DnssecError is a validation failure along with the extended DNS error code explaining it (RFC 8914).
+type DnssecError struct {
+	Ede uint16
+	err error
+}
+
+func NewDnssecError(ede uint16, format string, a ...interface{}) error {
+	return DnssecError{Ede: ede, err: fmt.Errorf(format, a...)}
+}
+
+func (e DnssecError) Error() string {
+	return e.err.Error()
+}
+
+func (e DnssecError) Unwrap() error {
+	return e.err
+}
+
+// NewDnssecResult classifies the outcome of a validation:
+// a proven insecure delegation is insecure, an upstream failure is indeterminate, any other failure is bogus.
+func NewDnssecResult(err error) model.DnssecResult {
+
+	if err == nil {
+		return model.DnssecResult{Status: model.DnssecSecure}
+	}
+
+	status := model.DnssecBogus
+	ede := dns.ExtendedErrorCodeDNSBogus
+
+	var e DnssecError
+	isDnssecError := errors.As(err, &e)
+	if isDnssecError {
+		ede = e.Ede
+		if ede == dns.ExtendedErrorCodeNetworkError {
+			status = model.DnssecIndeterminate
+		}
+	}
+
+	// an insecure delegation is explained only when it is caused by an unsupported algorithm (RFC 8914 §4.2).
+	if errors.Is(err, ErrInsecureDelegation) {
+		if !isDnssecError {
+			return model.DnssecResult{Status: model.DnssecInsecure}
+		}
+		status = model.DnssecInsecure
+	}
+
+	text := err.Error()
+	if len(text) > maxEdeText {
+		text = text[:maxEdeText]
+	}
+
+	return model.DnssecResult{
+		Status: status,
+		Ede:    &dns.EDNS0_EDE{InfoCode: ede, ExtraText: text},
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"time"
+)
+
+const (
+	DefaultAnchorsPath    = "/tmp/badger-anchors"
+	DefaultAnchorRefresh  = 12 * time.Hour      // interval between two queries of the root DNSKEY set.
+	DefaultAnchorHoldDown = 30 * 24 * time.Hour // add and remove hold-down times (RFC 5011 §2.4.1).
+
+	anchorsKey = "rfc5011/."
+)
+
+// DefaultAnchorsBadgerConfig stores the trust anchors apart from the cache, so that they are never pruned nor flushed.
+// A corrupted database is not recovered: starting again from the bootstrap anchors would forget the tracked rollovers.
+func DefaultAnchorsBadgerConfig() BadgerConfig {
+	conf := DefaultBadgerConfig()
+	conf.Path = DefaultAnchorsPath
+	conf.MaxSize = 0
+	conf.Recover = false
+	return conf
+}
+
+type DnssecAnchorTrackerConfig struct {
+	Refresh  time.Duration
+	HoldDown time.Duration
+}
+
+func DefaultDnssecAnchorTrackerConfig() DnssecAnchorTrackerConfig {
+	return DnssecAnchorTrackerConfig{
+		Refresh:  DefaultAnchorRefresh,
+		HoldDown: DefaultAnchorHoldDown,
+	}
+}
+
+// DnssecAnchorTracker follows the rollovers of the root key-signing keys with RFC 5011.
+// The keys and their states are persisted, the embedded IANA file is only used to bootstrap the first run.
+type DnssecAnchorTracker struct {
+	resolver DnsResolverProxy
+	anchors  DnssecTrustAnchors
+	db       Badger
+	conf     DnssecAnchorTrackerConfig
+	done     chan struct{}
+}
+
+// NewDnssecAnchorTracker restores the persisted trust anchors, if any, into anchors.
+func NewDnssecAnchorTracker(resolver DnsResolverProxy, anchors DnssecTrustAnchors, db Badger, conf DnssecAnchorTrackerConfig) DnssecAnchorTracker {
+	var tr DnssecAnchorTracker
+	defer t.Logger().Printf("%s initialized", &tr)
+	tr.resolver = resolver
+	tr.anchors = anchors
+	tr.db = db
+	tr.conf = conf
+	tr.done = make(chan struct{})
+	if state, found := tr.load(); found {
+		t.Logger().Printf("%s restored", state)
+		tr.anchors.Set(state.KeyDigests())
+	}
+	return tr
+}
+
+// ContinuouslyRefresh refreshes the trust anchors now, then periodically until Close is called.
+func (tr DnssecAnchorTracker) ContinuouslyRefresh() {
+	go func() {
+		ticker := time.NewTicker(tr.conf.Refresh)
+		defer ticker.Stop()
+		for {
+			if err := tr.Refresh(); err != nil {
+				t.LoggerError().Printf("unable to refresh trust anchors: %s", err.Error())
+			}
+			select {
+			case <-ticker.C:
+			case <-tr.done:
+				return
+			}
+		}
+	}()
+}
+
+// Refresh queries the root DNSKEY set, verifies it is signed by a trusted key,
+// then updates, persists and publishes the trust anchors.
+func (tr DnssecAnchorTracker) Refresh() error {
+
+	now := t.Now()
+
+	rm, err := tr.resolver.Proxy(model.NewDnsMsg(h.Msg(".", dns.TypeDNSKEY, dns.ClassINET)))
+	if err != nil {
+		return fmt.Errorf("unable to query root DNSKEY: %w", err)
+	}
+
+	if err := VerifyTrustedKeySet(rm, tr.anchors.KeyDigests(), now); err != nil {
+		return fmt.Errorf("root DNSKEY set is not trusted: %w", err)
+	}
+
+	state, found := tr.load()
+	if !found {
+		state = BootstrapTrustAnchors(".", rm, tr.anchors.KeyDigests(), now)
+	}
+
+	state = UpdateTrustAnchors(state, rm, now, tr.conf.HoldDown)
+
+	b, err := state.AsBytes()
+	if err != nil {
+		return fmt.Errorf("unable to encode trust anchors: %w", err)
+	}
+	if err := tr.db.Save(anchorsKey, b); err != nil {
+		return fmt.Errorf("unable to save trust anchors: %w", err)
+	}
+
+	tr.anchors.Set(state.KeyDigests())
+	t.Logger().Printf("%s refreshed", state)
+
+	return nil
+}
+
+func (tr DnssecAnchorTracker) load() (model.TrustAnchors, bool) {
+
+	b, found, err := tr.db.Load(anchorsKey)
+	if err != nil {
+		t.LoggerError().Printf("unable to load trust anchors: %s", err.Error())
+		return model.TrustAnchors{}, false
+	}
+	if !found {
+		return model.TrustAnchors{}, false
+	}
+
+	state, err := model.NewTrustAnchorsFromBytes(b)
+	if err != nil {
+		t.LoggerError().Printf("found corrupted trust anchors: %s", err.Error())
+		return model.TrustAnchors{}, false
+	}
+
+	return state, true
+}
+
+func (tr DnssecAnchorTracker) Close() {
+	close(tr.done)
+}
+
+func (tr DnssecAnchorTracker) String() string {
+	return fmt.Sprintf("DnssecAnchorTracker refresh=%s holdDown=%s", tr.conf.Refresh, tr.conf.HoldDown)
+}
+
+// VerifyTrustedKeySet verifies that the DNSKEY set is signed by one of the trusted keys.
+func VerifyTrustedKeySet(rm model.DnsMsg, digests []model.IanaKeyDigest, now time.Time) error {
+
+	for _, rrsig := range rm.GetRRSIG() {
+
+		key := rm.ByKeyTag(rrsig.KeyTag)
+		if key == nil || key.Flags&dns.REVOKE != 0 {
+			continue
+		}
+
+		for _, d := range digests {
+			if VerifyDigest(key, d.ToDS()) == nil && VerifySig(key, rrsig, rm.GetRR(), now) == nil {
+				return nil
+			}
+		}
+	}
+
+	return NewDnssecError(dns.ExtendedErrorCodeDNSKEYMissing, "no trusted key signs the DNSKEY set")
+}
+
+// IsSelfSigned tells whether the key signs the DNSKEY set.
+func IsSelfSigned(rm model.DnsMsg, key *dns.DNSKEY, now time.Time) bool {
+	for _, rrsig := range rm.GetRRSIG() {
+		if rrsig.KeyTag == key.KeyTag() && rrsig.Algorithm == key.Algorithm && VerifySig(key, rrsig, rm.GetRR(), now) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// BootstrapTrustAnchors trusts right away the keys of the DNSKEY set matching the bootstrap digests.
+func BootstrapTrustAnchors(zone string, rm model.DnsMsg, digests []model.IanaKeyDigest, now time.Time) model.TrustAnchors {
+
+	state := model.TrustAnchors{Zone: zone, Anchors: make([]model.TrustAnchor, 0, len(digests))}
+
+	for _, key := range rm.GetDNSKEY() {
+		if key.Flags&dns.SEP == 0 || key.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		for _, d := range digests {
+			if VerifyDigest(key, d.ToDS()) == nil {
+				t.Logger().Printf("trust anchor %s keyTag=%d bootstrapped", zone, key.KeyTag())
+				state.Anchors = append(state.Anchors, model.TrustAnchor{Key: key.String(), State: model.TrustAnchorValid, Since: now})
+				break
+			}
+		}
+	}
+
+	return state
+}
+
+// UpdateTrustAnchors applies a DNSKEY set, verified with a trusted key, to the state of the trust anchors (RFC 5011 §4.2).
+//   - a new key is pending until it has been seen during the add hold-down time, it is then trusted.
+//   - a trusted key absent from the set is missing, it remains trusted.
+//   - a key published with the REVOKE flag and signing the set is revoked, then removed after the remove hold-down time.
+//   - a pending key absent from the set is forgotten.
+func UpdateTrustAnchors(state model.TrustAnchors, rm model.DnsMsg, now time.Time, holdDown time.Duration) model.TrustAnchors {
+
+	index := make(map[string]int)
+	for i, a := range state.Anchors {
+		if key, err := a.DNSKEY(); err == nil {
+			index[trustAnchorId(key)] = i
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, key := range rm.GetDNSKEY() {
+
+		// only the key-signing keys are tracked.
+		if key.Flags&dns.SEP == 0 {
+			continue
+		}
+
+		id := trustAnchorId(key)
+		i, found := index[id]
+
+		switch {
+		case key.Flags&dns.REVOKE != 0:
+			// a revocation must be signed by the revoked key itself (RFC 5011 §2.1).
+			if !found || !IsSelfSigned(rm, key, now) {
+				continue
+			}
+			seen[i] = true
+			if state.Anchors[i].State != model.TrustAnchorRevoked {
+				setTrustAnchorState(state.Zone, &state.Anchors[i], model.TrustAnchorRevoked, now)
+			}
+
+		case !found:
+			state.Anchors = append(state.Anchors, model.TrustAnchor{Key: key.String(), State: model.TrustAnchorAddPend, Since: now})
+			index[id] = len(state.Anchors) - 1
+			seen[index[id]] = true
+			t.Logger().Printf("trust anchor %s keyTag=%d: new key, add hold-down started", state.Zone, key.KeyTag())
+
+		default:
+			seen[i] = true
+			a := &state.Anchors[i]
+			switch {
+			case a.State == model.TrustAnchorAddPend && now.Sub(a.Since) >= holdDown:
+				setTrustAnchorState(state.Zone, a, model.TrustAnchorValid, now)
+			case a.State == model.TrustAnchorMissing:
+				setTrustAnchorState(state.Zone, a, model.TrustAnchorValid, now)
+			}
+		}
+	}
+
+	anchors := make([]model.TrustAnchor, 0, len(state.Anchors))
+	for i, a := range state.Anchors {
+
+		switch {
+		case a.State == model.TrustAnchorRevoked && now.Sub(a.Since) >= holdDown:
+			t.Logger().Printf("trust anchor %s keyTag=%d: revoked key removed", state.Zone, trustAnchorTag(a))
+			continue
+		case seen[i]:
+		case a.State == model.TrustAnchorAddPend:
+			t.Logger().Printf("trust anchor %s keyTag=%d: pending key withdrawn", state.Zone, trustAnchorTag(a))
+			continue
+		case a.State == model.TrustAnchorValid:
+			setTrustAnchorState(state.Zone, &a, model.TrustAnchorMissing, now)
+		}
+
+		anchors = append(anchors, a)
+	}
+
+	state.Anchors = anchors
+	state.Updated = now
+
+	return state
+}
+
+func setTrustAnchorState(zone string, a *model.TrustAnchor, s model.TrustAnchorState, now time.Time) {
+	t.Logger().Printf("trust anchor %s keyTag=%d: %s -> %s", zone, trustAnchorTag(*a), a.State, s)
+	a.State = s
+	a.Since = now
+}
+
+// trustAnchorId identifies a key whatever its flags, the REVOKE flag changes its key tag.
+func trustAnchorId(key *dns.DNSKEY) string {
+	return fmt.Sprintf("%d/%s", key.Algorithm, key.PublicKey)
+}
+
+func trustAnchorTag(a model.TrustAnchor) uint16 {
+	if key, err := a.DNSKEY(); err == nil {
+		return key.KeyTag()
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	"testing"
+	"time"
+)
+
+// NewTestKeySet returns the DNSKEY set of the root made of keys, signed by each of the signers
+// for the year to come, so that the set can be verified along the hold-down times.
+func NewTestKeySet(t *testing.T, keys []*dns.DNSKEY, signers ...TestZone) model.DnsMsg {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeDNSKEY)
+	rrset := make([]dns.RR, 0, len(keys))
+	for _, k := range keys {
+		rrset = append(rrset, k)
+	}
+	m.Answer = append(m.Answer, rrset...)
+	for _, s := range signers {
+		m.Answer = append(m.Answer, SignTestRRsetPeriod(t, s.key, s.signer, time.Now().Add(-time.Hour), time.Now().AddDate(1, 0, 0), rrset...))
+	}
+	return model.NewDnsMsg(m)
+}
+
+// Revoked returns the zone signing with its key flagged REVOKE.
+func (z TestZone) Revoked() TestZone {
+	key := *z.key
+	key.Flags |= dns.REVOKE
+	return TestZone{key: &key, signer: z.signer}
+}
+
+func TestUpdateTrustAnchors(t *testing.T) {
+
+	stub := NewDnsResolverZonesStub()
+	old := NewTestZone(t, stub, ".", nil)
+	next := NewTestZone(t, stub, ".", nil)
+	pending := NewTestZone(t, stub, ".", nil)
+
+	states := func(state model.TrustAnchors) map[uint16]model.TrustAnchorState {
+		m := make(map[uint16]model.TrustAnchorState)
+		for _, a := range state.Anchors {
+			m[trustAnchorTag(a)] = a.State
+		}
+		return m
+	}
+
+	now := time.Now()
+	holdDown := 30 * 24 * time.Hour
+
+	state := BootstrapTrustAnchors(".", NewTestKeySet(t, []*dns.DNSKEY{old.key}, old), old.Anchors().KeyDigest, now)
+
+	tests := []struct {
+		name     string
+		rm       model.DnsMsg
+		at       time.Duration
+		expected map[uint16]model.TrustAnchorState
+	}{
+		{"new key", NewTestKeySet(t, []*dns.DNSKEY{old.key, next.key}, old), 0,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorValid, next.key.KeyTag(): model.TrustAnchorAddPend}},
+		{"hold-down running", NewTestKeySet(t, []*dns.DNSKEY{old.key, next.key, pending.key}, old), holdDown / 2,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorValid, next.key.KeyTag(): model.TrustAnchorAddPend, pending.key.KeyTag(): model.TrustAnchorAddPend}},
+		{"hold-down expired, pending key withdrawn", NewTestKeySet(t, []*dns.DNSKEY{old.key, next.key}, old), holdDown,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorValid, next.key.KeyTag(): model.TrustAnchorValid}},
+		{"forged revocation", NewTestKeySet(t, []*dns.DNSKEY{old.Revoked().key, next.key}, next), holdDown,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorMissing, next.key.KeyTag(): model.TrustAnchorValid}},
+		{"revocation", NewTestKeySet(t, []*dns.DNSKEY{old.Revoked().key, next.key}, old.Revoked(), next), holdDown + time.Hour,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorRevoked, next.key.KeyTag(): model.TrustAnchorValid}},
+		{"revoked key kept during remove hold-down", NewTestKeySet(t, []*dns.DNSKEY{next.key}, next), 2 * holdDown,
+			map[uint16]model.TrustAnchorState{old.key.KeyTag(): model.TrustAnchorRevoked, next.key.KeyTag(): model.TrustAnchorValid}},
+		{"revoked key removed", NewTestKeySet(t, []*dns.DNSKEY{next.key}, next), 2*holdDown + time.Hour,
+			map[uint16]model.TrustAnchorState{next.key.KeyTag(): model.TrustAnchorValid}},
+	}
+
+	for _, tt := range tests {
+		state = UpdateTrustAnchors(state, tt.rm, now.Add(tt.at), holdDown)
+		received := states(state)
+		if len(received) != len(tt.expected) {
+			t.Fatalf("%s: expected %v, received %v", tt.name, tt.expected, received)
+		}
+		for tag, s := range tt.expected {
+			if received[tag] != s {
+				t.Fatalf("%s: expected %v, received %v", tt.name, tt.expected, received)
+			}
+		}
+	}
+
+	digests := state.KeyDigests()
+	if len(digests) != 1 || digests[0].KeyTag != next.key.KeyTag() {
+		t.Fatalf("expected the trust anchor of the new key only, received %v", digests)
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecAnchorTracker(t *testing.T) {
+
+	dir := t.TempDir()
+	z := NewTestZones(t)
+	next := NewTestZone(t, NewDnsResolverZonesStub(), ".", nil)
+
+	// the IANA bootstrap anchor trusts the current root key only.
+	anchors := NewDnssecTrustAnchors(z.root.Anchors())
+	z.stub.Add(".", dns.TypeDNSKEY, dns.RcodeSuccess, NewTestKeySet(t, []*dns.DNSKEY{z.root.key, next.key}, z.root).GetMsg().Answer, nil)
+
+	db := NewTestBadger(t, dir)
+	tracker := NewDnssecAnchorTracker(z.stub, anchors, db, DnssecAnchorTrackerConfig{Refresh: time.Hour, HoldDown: 0})
+	if err := tracker.Refresh(); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if len(anchors.KeyDigests()) != 1 {
+		t.Fatalf("expected the new key to be pending, received %v", anchors)
+	}
+
+	// the hold-down is over, the new key is trusted.
+	if err := tracker.Refresh(); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if len(anchors.KeyDigests()) != 2 {
+		t.Fatalf("expected both keys to be trusted, received %v", anchors)
+	}
+	db.Close()
+
+	// the state is restored from the database whatever the bootstrap anchors, the validators see it at runtime.
+	db = NewTestBadger(t, dir)
+	defer db.Close()
+	restored := NewDnssecTrustAnchors(next.Anchors())
+	validator := NewDnssecValidatorWithAnchors(z.stub, restored)
+	rm := model.NewDnsMsg(h.Msg("www.example.", dns.TypeA, dns.ClassINET))
+
+	in, _ := z.stub.Proxy(rm)
+	if _, err := validator.Validate(in); err == nil {
+		t.Fatalf("expected the root keys not to be trusted before the state is restored")
+	}
+
+	NewDnssecAnchorTracker(z.stub, restored, db, DefaultDnssecAnchorTrackerConfig())
+	if len(restored.KeyDigests()) != 2 {
+		t.Fatalf("expected both keys to be restored, received %v", restored)
+	}
+
+	in, _ = z.stub.Proxy(rm)
+	if _, err := validator.Validate(in); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	// a DNSKEY set signed by an unknown key is not applied.
+	z.stub.Add(".", dns.TypeDNSKEY, dns.RcodeSuccess, NewTestKeySet(t, []*dns.DNSKEY{next.key}, NewTestZone(t, NewDnsResolverZonesStub(), ".", nil)).GetMsg().Answer, nil)
+	if err := NewDnssecAnchorTracker(z.stub, NewDnssecTrustAnchors(z.root.Anchors()), db, DefaultDnssecAnchorTrackerConfig()).Refresh(); err == nil {
+		t.Fatalf("expected an untrusted DNSKEY set to be rejected")
+	}
+
+	t.Logf("Success !")
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTrustCacheSize = 1 << 20
+	trustCacheMaxTTL      = 24 * time.Hour
+)
+
+// DnssecTrustCache keeps the keys of the zones whose chain of trust has been validated,
+// so that validating a name only verifies the zones below the deepest trusted one.
+// A zone proven insecure is kept as well, along with the proof of its unsigned delegation.
+// Entries expire with the first of the TTLs and the RRSIG expirations of the records they were validated with.
+type DnssecTrustCache struct {
+	storage DnsCacheStorage
+}
+
+func NewDnssecTrustCache(storage DnsCacheStorage) DnssecTrustCache {
+	var c DnssecTrustCache
+	defer t.Logger().Printf("%s initialized", &c)
+	c.storage = storage
+	return c
+}
+
+// Get returns the validated keys of the zone, or the proof of its insecure delegation.
+func (c DnssecTrustCache) Get(zone string) (model.DnsMsg, bool) {
+
+	entry, found := c.storage.Get(strings.ToLower(zone))
+	if !found || entry.IsExpired() {
+		return model.DnsMsg{}, false
+	}
+
+	keys, err := entry.Value()
+	if err != nil {
+		t.LoggerError().Printf("found corrupted trusted zone %s: %s", zone, err.Error())
+		return model.DnsMsg{}, false
+	}
+
+	return keys, true
+}
+
+// Closest returns the deepest zone of domain present in the cache, along with its number of labels.
+func (c DnssecTrustCache) Closest(domain string) (model.DnsMsg, int, bool) {
+	for deep := dns.CountLabel(domain); deep >= 0; deep-- {
+		if keys, found := c.Get(h.SubZone(domain, deep)); found {
+			return keys, deep, true
+		}
+	}
+	return model.DnsMsg{}, 0, false
+}
+
+// Store keeps the keys of the zone with the result of its validation,
+// until the first expiry of the records of the keys and of the proofs.
+func (c DnssecTrustCache) Store(zone string, now time.Time, keys model.DnsMsg, proofs ...model.DnsMsg) {
+
+	ttl := trustTTL(now, append(proofs, keys)...)
+	if ttl <= 0 {
+		return
+	}
+
+	entry, err := model.NewDnsCacheEntry(keys, ttl)
+	if err != nil {
+		t.LoggerError().Printf("unable to pack trusted zone %s: %s", zone, err.Error())
+		return
+	}
+
+	if err = c.storage.Set(strings.ToLower(zone), entry); err != nil {
+		t.LoggerError().Printf("unable to store trusted zone %s: %s", zone, err.Error())
+		return
+	}
+
+	t.LogDnssec("zone %s : %s, trusted for %s", zone, keys.GetDnssecResult(), ttl)
+}
+
+// trustTTL returns the time the messages can be trusted: the first of the expiry of their records and of their signatures.
+func trustTTL(now time.Time, msgs ...model.DnsMsg) time.Duration {
+
+	ttl := trustCacheMaxTTL
+
+	for _, m := range msgs {
+		for _, section := range [][]dns.RR{m.GetMsg().Answer, m.GetMsg().Ns} {
+			for _, rr := range section {
+				if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+					ttl = d
+				}
+				if rrsig, ok := rr.(*dns.RRSIG); ok {
+					if d := time.Unix(int64(rrsig.Expiration), 0).Sub(now); d < ttl {
+						ttl = d
+					}
+				}
+			}
+		}
+	}
+
+	return ttl
+}
+
+func (c DnssecTrustCache) String() string {
+	return fmt.Sprintf("DnssecTrustCache %s", c.storage.Name())
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/miekg/dns"
 	h "golang-dns/internal/helpers"
@@ -13,12 +14,21 @@ import (
 
 const (
 	nonBlockingChannel = 10
+	nsec3OptOut        = 1 // opt-out flag of NSEC3 records (RFC 5155 §3.1.2.1)
 )
 
+// ErrInsecureDelegation is returned when the chain of trust proves that the zone is not signed.
+var ErrInsecureDelegation = errors.New("insecure delegation")
+
 type DnssecValidator struct {
 	resolver      DnsResolverProxy
 	asyncResolver AsyncDnsResolver
-	anchors       model.IanaAnchors
+	anchors       DnssecTrustAnchors
+	trust         DnssecTrustCache
+	policy        DnssecPolicy
+	clock         t.Clock // tells the time the signatures are verified at, the shared clock when nil.
+	aggressive    DnssecAggressiveCache
+	tracer        DnssecTracer // follows the steps of the validation when set, ex: to explain it.
 }
 
 func NewDnssecValidator(resolver DnsResolverProxy) DnssecValidator {
@@ -26,20 +36,276 @@ func NewDnssecValidator(resolver DnsResolverProxy) DnssecValidator {
 }
 
 func NewDnssecValidatorFromIanaFile(resolver DnsResolverProxy, anchors model.IanaAnchors) DnssecValidator {
+	return NewDnssecValidatorWithAnchors(resolver, NewDnssecTrustAnchors(anchors))
+}
+
+// NewDnssecValidatorWithAnchors returns a validator trusting the root keys of anchors, which may be updated at runtime.
+func NewDnssecValidatorWithAnchors(resolver DnsResolverProxy, anchors DnssecTrustAnchors) DnssecValidator {
 	var v DnssecValidator
 	defer t.Logger().Printf("%s initialized", &v)
 	v.resolver = resolver
 	v.asyncResolver = NewAsyncDnsResolverImpl(resolver)
 	v.anchors = anchors
+	v.trust = NewDnssecTrustCache(NewLru(DefaultTrustCacheSize))
+	v.policy, _ = NewDnssecPolicy()
 	return v
 }
 
+// WithPolicy returns the validator applying the validation modes of policy.
+func (s DnssecValidator) WithPolicy(policy DnssecPolicy) DnssecValidator {
+	s.policy = policy
+	return s
+}
+
+// WithClock returns the validator verifying the validity periods of the signatures at the time of clock,
+// ex: a fixed time to validate recorded responses.
+func (s DnssecValidator) WithClock(clock t.Clock) DnssecValidator {
+	s.clock = clock
+	return s
+}
+
+// Now returns the time of the clock of the validator.
+func (s DnssecValidator) Now() time.Time {
+	if s.clock == nil {
+		return t.Now()
+	}
+	return s.clock.Now()
+}
+
+// WithAggressiveCache returns the validator keeping the denial records of the validated negative responses in cache,
+// to synthesize the negative responses they prove (RFC 8198).
+func (s DnssecValidator) WithAggressiveCache(cache DnssecAggressiveCache) DnssecValidator {
+	s.aggressive = cache
+	return s
+}
+
+// Synthesize answers the request from the denial records of the aggressive cache, without querying upstream.
+// The requests whose domain is not validated by the policy are never synthesized.
+func (s DnssecValidator) Synthesize(rm model.DnsMsg, def model.DnssecMode) (model.DnsMsg, bool) {
+
+	if !s.aggressive.IsEnabled() || s.policy.Mode(rm.GetQuestion().Name, def) == model.DnssecSkip {
+		return rm, false
+	}
+
+	m, found := s.aggressive.Lookup(rm.GetMsg(), s.Now())
+	if !found {
+		return rm, false
+	}
+
+	t.CountDnssec("synthesized")
+	t.LogDnssec("%s: negative response synthesized from the aggressive cache", rm.GetQuestion().Name)
+
+	return model.NewDnsMsg(m).WithDnssecResult(NewDnssecResult(nil)), true
+}
+
+// ValidateWithPolicy validates the response following the mode of its domain, def when the policy has no rule for it.
+func (s DnssecValidator) ValidateWithPolicy(in model.DnsMsg, def model.DnssecMode) (model.DnsMsg, error) {
+
+	name := in.GetQuestion().Name
+	mode := s.policy.Mode(name, def)
+
+	if mode == model.DnssecSkip {
+		t.CountDnssec("skipped")
+		t.Logger().Printf("%s: dnssec validation skipped by policy", name)
+		return in, nil
+	}
+
+	in, err := s.Validate(in)
+	if err != nil {
+		return in, err
+	}
+
+	// unsigned and insecure responses are rejected
+	if mode == model.DnssecEnforce && !in.IsValidated() {
+		err = NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no dnssec signature")
+		return in.WithDnssecResult(NewDnssecResult(err)), err
+	}
+
+	return in, nil
+}
+
+// Validate verifies the response and attaches the result of the validation to it.
+// The unsigned RRsets of a positive answer, signed or not, are insecure when the chain of trust proves that the zone of their owner
+// is not signed, bogus otherwise.
+// The failures of the upstream (SERVFAIL, REFUSED...) are not validated, their status remains indeterminate.
+// An error is returned along with bogus and indeterminate results.
+func (s DnssecValidator) Validate(in model.DnsMsg) (model.DnsMsg, error) {
+
+	var err error
+
+	switch {
+	case in.IsNegative():
+		err = s.VerifyNegative(in)
+	case in.IsRRSIG():
+		err = s.Verify(in)
+	case in.GetMsg().Rcode == dns.RcodeSuccess:
+		err = s.VerifyUnsigned(in)
+	default:
+		t.CountDnssec("unvalidated")
+		return in, nil
+	}
+
+	result := NewDnssecResult(err)
+	t.CountDnssec(result.Status.String())
+
+	if result.Status == model.DnssecInsecure {
+		err = nil
+	}
+
+	in = in.WithDnssecResult(result)
+	if result.Status == model.DnssecSecure {
+		in = s.StripUnverified(in)
+	}
+	s.aggressive.Store(in, s.Now())
+
+	return in, err
+}
+
+// StripUnverified removes from the authority and additional sections of a secure response the RRsets which are not signed,
+// or whose signatures do not verify with the keys of their zone, so that no record is passed along with the AD bit unvalidated,
+// ex: glue records added by the upstream. The authority section of a negative response has already been verified.
+func (s DnssecValidator) StripUnverified(rm model.DnsMsg) model.DnsMsg {
+
+	m := rm.GetMsg().Copy()
+	m.Ns = s.verifiedSection(rm, m.Ns, rm.IsNegative())
+	m.Extra = s.verifiedSection(rm, m.Extra, false)
+
+	return model.NewDnsMsg(m).WithDnssecResult(rm.GetDnssecResult())
+}
+
+// verifiedSection returns the records of the RRsets of the section which verify, the signed ones are kept as is when verified.
+func (s DnssecValidator) verifiedSection(rm model.DnsMsg, section []dns.RR, verified bool) []dns.RR {
+
+	name := rm.GetQuestion().Name
+	rrsets := model.NewDnsRRsets(section)
+
+	// group the signed RRsets by signer, the chain of trust of each zone is validated once.
+	signed := make(map[string][]model.DnsRRset)
+	for _, rrset := range rrsets {
+		if len(rrset.Signatures) == 0 || verified {
+			continue
+		}
+		zone := strings.ToLower(rrset.Signatures[0].SignerName)
+		if !dns.IsSubDomain(zone, rrset.Name) {
+			t.LogDnssec("%s: RRset stripped: signer %s is not an ancestor of %s", name, zone, rrset)
+			continue
+		}
+		signed[zone] = append(signed[zone], rrset)
+	}
+
+	valid := make(map[string]bool)
+	for zone, zoneRRsets := range signed {
+		if err := s.NewDnssecRecursion().RunVerifyEachRRset(zone, zoneRRsets, rm, valid); err != nil {
+			t.LogDnssec("%s: RRsets of %s stripped: %v", name, zone, err)
+		}
+	}
+
+	kept := make([]dns.RR, 0, len(section))
+	for _, rrset := range rrsets {
+		switch {
+		case rrset.Type == dns.TypeOPT:
+		case len(rrset.Signatures) == 0:
+			t.LogDnssec("%s: unsigned RRset stripped: %s", name, rrset)
+			t.CountDnssec("stripped")
+			continue
+		case !verified && !valid[rrset.String()]:
+			t.CountDnssec("stripped")
+			continue
+		}
+		kept = append(kept, rrset.AsRR()...)
+	}
+
+	return kept
+}
+
 func (s DnssecValidator) Verify(rm model.DnsMsg) error {
 
-	err := s.NewDnssecRecursion().RunVerify(rm)
+	err := s.VerifyAnswer(rm)
+
+	if err != nil {
+		return fmt.Errorf("signature is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyAnswer verifies every RRset of the answer section against the zone which signed it,
+// a CNAME/DNAME chain may cross several zones. An unsigned RRset is insecure when the chain of trust
+// proves that the zone of its owner is not signed, ex: the target of a CNAME in an insecure zone (RFC 4035 §5.2), bogus otherwise.
+func (s DnssecValidator) VerifyAnswer(rm model.DnsMsg) error {
+
+	rrsets := model.NewDnsRRsets(rm.GetMsg().Answer)
+
+	if err := VerifyCnameChain(rm.GetQuestion(), rrsets); err != nil {
+		return err
+	}
+
+	// group the RRsets by signer, each zone is validated once.
+	zones := make([]string, 0, 2)
+	signed := make(map[string][]model.DnsRRset)
+	var insecure error
+
+	for _, rrset := range rrsets {
+
+		if len(rrset.Signatures) == 0 {
+			// the CNAME synthesized from a DNAME is not signed, the DNAME is.
+			if IsSynthesizedCname(rrset, rrsets) {
+				continue
+			}
+			if err := s.VerifyInsecure(rrset); !errors.Is(err, ErrInsecureDelegation) {
+				return err
+			}
+			insecure = fmt.Errorf("unsigned %s: %w", rrset, ErrInsecureDelegation)
+			continue
+		}
+
+		zone := strings.ToLower(rrset.Signatures[0].SignerName)
+		if !dns.IsSubDomain(zone, rrset.Name) {
+			return fmt.Errorf("signer %s is not an ancestor of %s", zone, rrset)
+		}
+
+		if _, found := signed[zone]; !found {
+			zones = append(zones, zone)
+		}
+		signed[zone] = append(signed[zone], rrset)
+	}
+
+	for _, zone := range zones {
+		if err := s.NewDnssecRecursion().RunVerifyRRsets(zone, signed[zone], rm); err != nil {
+			return err
+		}
+	}
+
+	return insecure
+}
+
+// VerifyInsecure walks the chain of trust down to the owner of the unsigned RRset:
+// it fails with ErrInsecureDelegation when a zone on the way is not signed, with a missing signature error otherwise.
+func (s DnssecValidator) VerifyInsecure(rrset model.DnsRRset) error {
+	return s.NewDnssecRecursion().RunVerifyUnsigned(rrset.Name)
+}
+
+// VerifyNegative verifies a NXDOMAIN or NODATA response with the signed SOA and NSEC/NSEC3 records of its authority section.
+// An unsigned negative response is only accepted when the chain of trust proves that its zone is not signed.
+func (s DnssecValidator) VerifyNegative(rm model.DnsMsg) error {
+
+	err := s.NewDnssecRecursion().RunVerifyNegative(rm)
 
 	if err != nil {
-		return fmt.Errorf("signature is invalid: %s", err)
+		return fmt.Errorf("denial of existence is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyUnsigned verifies a positive answer without any signature, as an answer mixing signed and unsigned RRsets:
+// each RRset is only accepted when the chain of trust proves that the zone of its owner is not signed.
+func (s DnssecValidator) VerifyUnsigned(rm model.DnsMsg) error {
+
+	err := s.VerifyAnswer(rm)
+
+	if err != nil {
+		return fmt.Errorf("unsigned answer: %w", err)
 	}
 
 	return nil
@@ -54,6 +320,7 @@ func (_ DnssecValidator) String() string {
 type DnssecRecursion struct {
 	validator DnssecValidator
 	zone      chan DnssecRecursionZone
+	now       time.Time // the whole chain of trust is verified at the same time.
 }
 
 type DnssecRecursionZone struct {
@@ -66,6 +333,7 @@ func (s DnssecValidator) NewDnssecRecursion() DnssecRecursion {
 	return DnssecRecursion{
 		validator: s,
 		zone:      make(chan DnssecRecursionZone, nonBlockingChannel),
+		now:       s.Now(),
 	}
 }
 
@@ -77,16 +345,136 @@ func (recursion DnssecRecursion) PushToChan(zone string, keyAsyncResult, dsAsync
 	}
 }
 
-func (recursion DnssecRecursion) RunVerify(rm model.DnsMsg) error {
-	dn := rm.GetRrsigDN()
-	recursion.Recurse(0, dn, ".")
-	err := recursion.Verify(rm)
-	return err
+// RunVerifyRRsets verifies the RRsets of the response signed by the zone.
+func (recursion DnssecRecursion) RunVerifyRRsets(zone string, rrsets []model.DnsRRset, rm model.DnsMsg) error {
+
+	trusted, found, err := recursion.Start(zone)
+	if err != nil {
+		return err
+	}
+
+	return recursion.VerifyChain(trusted, found, func(keys model.DnsMsg) error {
+		for _, rrset := range rrsets {
+			if err := VerifyRRset(keys, rrset, recursion.now); err != nil {
+				return err
+			}
+			if err := VerifyWildcard(rm, rrset, keys, recursion.now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RunVerifyEachRRset verifies the RRsets of the response signed by the zone one by one,
+// marking the ones which verify as valid, and returns the error of the last one which does not.
+func (recursion DnssecRecursion) RunVerifyEachRRset(zone string, rrsets []model.DnsRRset, rm model.DnsMsg, valid map[string]bool) error {
+
+	trusted, found, err := recursion.Start(zone)
+	if err != nil {
+		return err
+	}
+
+	return recursion.VerifyChain(trusted, found, func(keys model.DnsMsg) error {
+		var last error
+		for _, rrset := range rrsets {
+			if valid[rrset.String()] {
+				continue
+			}
+			err := VerifyRRset(keys, rrset, recursion.now)
+			if err == nil {
+				err = VerifyWildcard(rm, rrset, keys, recursion.now)
+			}
+			if err != nil {
+				last = err
+				continue
+			}
+			valid[rrset.String()] = true
+		}
+		return last
+	})
+}
+
+func (recursion DnssecRecursion) RunVerifyNegative(rm model.DnsMsg) error {
+
+	name := rm.GetQuestion().Name
+	zone := rm.GetSoaZone()
+	if zone == "" {
+		zone = name
+	}
+
+	// the authority of the response must be an ancestor of the name, or the name itself.
+	if !dns.IsSubDomain(zone, name) {
+		return fmt.Errorf("zone %s is not an ancestor of %s", zone, name)
+	}
+
+	trusted, found, err := recursion.Start(zone)
+	if err != nil {
+		return err
+	}
+
+	return recursion.VerifyChain(trusted, found, func(keys model.DnsMsg) error {
+		if err := VerifyAuthority(rm, keys, recursion.now, dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3); err != nil {
+			return err
+		}
+		return VerifyDenial(rm, keys, recursion.now)
+	})
 }
 
-func (recursion DnssecRecursion) Verify(rm model.DnsMsg) error {
+// RunVerifyUnsigned walks the chain of trust down to the name of an unsigned RRset:
+// it fails with ErrInsecureDelegation when a zone on the way is not signed, with a missing signature error otherwise.
+func (recursion DnssecRecursion) RunVerifyUnsigned(name string) error {
+
+	trusted, found, err := recursion.Start(name)
+	if err != nil {
+		return err
+	}
+
+	return recursion.VerifyChain(trusted, found, func(keys model.DnsMsg) error {
+		return NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no signature: %s", name)
+	})
+}
+
+// Start queues the zones to validate down to domain, below the deepest zone of the trust cache.
+// It returns the keys of that zone if any, or ErrInsecureDelegation when the zone is known to be insecure.
+func (recursion DnssecRecursion) Start(domain string) (model.DnsMsg, bool, error) {
+
+	trusted, deep, found := recursion.validator.trust.Closest(domain)
+	if !found {
+		recursion.Recurse(0, domain, ".")
+		return trusted, false, nil
+	}
+
+	if trusted.GetDnssecResult().Status == model.DnssecInsecure {
+		t.LogDnssec("zone %s : insecure delegation (cached)", h.SubZone(domain, deep))
+		return trusted, true, ErrInsecureDelegation
+	}
+
+	t.LogDnssec("zone %s : trusted (cached)", h.SubZone(domain, deep))
+
+	if deep < dns.CountLabel(domain) {
+		recursion.Recurse(deep+1, domain, h.SubZone(domain, deep+1))
+	}
+
+	return trusted, true, nil
+}
+
+// VerifyChain validates the zones queued by Recurse, starting from the trusted keys if found, from the root otherwise,
+// until the final check succeeds with the DNSKEY of one of them.
+func (recursion DnssecRecursion) VerifyChain(trusted model.DnsMsg, found bool, final func(keys model.DnsMsg) error) error {
 
 	var previousDnsKeyResponse model.DnsMsg
+	var finalErr error
+
+	if found {
+		finalErr = final(trusted)
+		recursion.traceFinal(trusted, finalErr)
+		if finalErr == nil {
+			t.LogDnssec("final RRSIG is valid in trusted zone")
+			return nil
+		}
+		previousDnsKeyResponse = trusted
+	}
 
 	for len(recursion.zone) > 0 {
 
@@ -94,20 +482,32 @@ func (recursion DnssecRecursion) Verify(rm model.DnsMsg) error {
 
 		dnsKeyResp, err := zone.keyAsyncResult.Result()
 		if err != nil {
-			return fmt.Errorf("unable to query DNSKEY: %s", err.Error())
+			err = NewDnssecError(dns.ExtendedErrorCodeNetworkError, "unable to query DNSKEY: %w", err)
+			recursion.traceZone(zone.zone, dnsKeyResp, model.DnsMsg{}, previousDnsKeyResponse, err)
+			return err
 		}
 
 		dsResp, err := zone.dsAsyncResult.Result()
 		if err != nil {
-			return fmt.Errorf("unable to query DS: %s", err.Error())
+			err = NewDnssecError(dns.ExtendedErrorCodeNetworkError, "unable to query DS: %w", err)
+			recursion.traceZone(zone.zone, dnsKeyResp, dsResp, previousDnsKeyResponse, err)
+			return err
 		}
 
-		err = recursion.VerifyZone(zone.zone, dnsKeyResp, dsResp, previousDnsKeyResponse, recursion.validator.anchors.KeyDigest)
+		keys, err := recursion.VerifyZone(zone.zone, dnsKeyResp, dsResp, previousDnsKeyResponse, recursion.validator.anchors.KeyDigests())
+		recursion.traceZone(zone.zone, dnsKeyResp, dsResp, previousDnsKeyResponse, err)
+		if errors.Is(err, ErrInsecureDelegation) {
+			recursion.validator.trust.Store(zone.zone, recursion.now, dsResp.WithDnssecResult(NewDnssecResult(err)), previousDnsKeyResponse)
+		}
 		if err != nil {
 			return err
 		}
 
-		if err = VerifySignature(dnsKeyResp, rm); err == nil {
+		recursion.validator.trust.Store(zone.zone, recursion.now, keys.AsValidated(), dsResp)
+
+		finalErr = final(keys)
+		recursion.traceFinal(keys, finalErr)
+		if finalErr == nil {
 			// found a DNSKEY in that zone which has the KeyTag of the final RRSIG
 			// does it verify the RRSIG ?
 			t.LogDnssec("final RRSIG is valid in zone: %s", zone.zone)
@@ -119,10 +519,22 @@ func (recursion DnssecRecursion) Verify(rm model.DnsMsg) error {
 		// some subdomains (ex: chrome.cloudflare-dns.com.) reuse the keys of their parents
 		// and the final RRSIG will only be valid with the DNSKEY of the final zone.
 
-		previousDnsKeyResponse = dnsKeyResp
+		previousDnsKeyResponse = keys
 	}
 
-	return fmt.Errorf("unable to find the right DNSKEY that signed the response")
+	return fmt.Errorf("unable to find the right DNSKEY that signed the response: %w", finalErr)
+}
+
+func (recursion DnssecRecursion) traceZone(zone string, dnsKeyResp, dsResp, parentDnsKeyResp model.DnsMsg, err error) {
+	if recursion.validator.tracer != nil {
+		recursion.validator.tracer.TraceZone(zone, dnsKeyResp, dsResp, parentDnsKeyResp, err)
+	}
+}
+
+func (recursion DnssecRecursion) traceFinal(keys model.DnsMsg, err error) {
+	if recursion.validator.tracer != nil {
+		recursion.validator.tracer.TraceFinal(keys, err)
+	}
 }
 
 func (recursion DnssecRecursion) Recurse(deep int, domain, zone string) {
@@ -147,14 +559,58 @@ func (recursion DnssecRecursion) Recurse(deep int, domain, zone string) {
 	recursion.Recurse(deep+1, domain, subZone)
 }
 
-func (recursion DnssecRecursion) VerifyZone(zone string, dnsKeyResp model.DnsMsg, dsResp model.DnsMsg, parentDnsKeyResp model.DnsMsg, anchors []model.IanaKeyDigest) error {
+// VerifyZone validates the DNSKEY of the zone against its DS in the parent zone,
+// and returns the keys which sign the records of the zone.
+// The DNSKEY set must be signed by a key matching a DS, or a trust anchor for the root:
+// any valid signature over the set is not enough, as it may be made by a key added to the set.
+// When the name is not a zone cut, its records are signed with the keys of the parent zone.
+func (recursion DnssecRecursion) VerifyZone(zone string, dnsKeyResp model.DnsMsg, dsResp model.DnsMsg, parentDnsKeyResp model.DnsMsg, anchors []model.IanaKeyDigest) (model.DnsMsg, error) {
 
 	t.LogDnssec("******** validating zone: %s", zone)
 
+	if zone != "." && dsResp.IsEmpty() {
+		// check presence of NSEC or NSEC3
+		if err := VerifyDenial(dsResp, parentDnsKeyResp, recursion.now); err != nil {
+			return dnsKeyResp, fmt.Errorf("zone: %s : invalid DS: %w", zone, err)
+		}
+
+		// We have proof of non-existence of the DS record:
+		// either the zone is not signed, or the name is not a zone cut.
+		if DenialIsDelegation(dsResp) {
+			t.LogDnssec("zone %s : insecure delegation", zone)
+			return dnsKeyResp, ErrInsecureDelegation
+		}
+
+		t.LogDnssec("zone %s : not a zone cut", zone)
+		return parentDnsKeyResp, nil
+	}
+
+	// ------ BEGIN DS VALIDATION ------
+	// the DS must be authenticated before its algorithms may prove an insecure delegation.
+	ds := make([]*dns.DS, 0)
+	if zone != "." {
+		t.LogDnssec("zone %s : verifying DS RRSIG", zone)
+
+		if err := VerifySignature(parentDnsKeyResp, dsResp, recursion.now); err != nil {
+			return dnsKeyResp, fmt.Errorf("zone: %s : invalid DS: %w", zone, err)
+		}
+		t.LogDnssec("zone %s : %T : valid", zone, &dns.DS{})
+
+		selected, err := GetDnssecAlgorithmPolicy().SelectDS(zone, dsResp.GetDS())
+		if err != nil {
+			return dnsKeyResp, err
+		}
+		ds = selected
+	}
+	// ------ END DS VALIDATION ------
+
 	// ------ BEGIN DNSKEY VALIDATION ------
 	t.LogDnssec("zone %s : verifying DNSKEY RRSIG", zone)
-	if err := VerifySignature(dnsKeyResp, dnsKeyResp); err != nil {
-		return fmt.Errorf("zone: %s : invalid DNSKEY: %s", zone, err.Error())
+	if dnsKeyResp.IsEmpty() {
+		return dnsKeyResp, NewDnssecError(dns.ExtendedErrorCodeDNSKEYMissing, "zone: %s : no DNSKEY", zone)
+	}
+	if err := VerifySignature(dnsKeyResp, dnsKeyResp, recursion.now); err != nil {
+		return dnsKeyResp, fmt.Errorf("zone: %s : invalid DNSKEY: %w", zone, err)
 	}
 	t.LogDnssec("zone %s : %T : valid", zone, &dns.DNSKEY{})
 	// ------ END DNSKEY VALIDATION ------
@@ -162,140 +618,510 @@ func (recursion DnssecRecursion) VerifyZone(zone string, dnsKeyResp model.DnsMsg
 	// At this point we know it is a zone (with a DS entry)
 
 	// ------ BEGIN DS DIGEST VALIDATION ------
+	// only the keys whose signature over the DNSKEY set verifies are matched.
 	if zone == "." {
 
-		if err := VerifyTrustAnchors(dnsKeyResp, anchors); err != nil {
-			return fmt.Errorf("unable to match trust anchor: %s", err.Error())
+		if err := VerifyTrustAnchors(dnsKeyResp, anchors, recursion.now); err != nil {
+			return dnsKeyResp, fmt.Errorf("unable to match trust anchor: %w", err)
 		}
 		t.LogDnssec("zone %s : matches DS parent (trust anchor)", zone)
 
 	} else {
 
-		// ------ BEGIN DS VALIDATION ------
-		t.LogDnssec("zone %s : verifying DS RRSIG", zone)
+		// generate DS from KSK and match against DS value
+		if err := VerifyDelegation(dnsKeyResp, ds, recursion.now); err != nil {
+			return dnsKeyResp, fmt.Errorf("zone: %s : unable to validate DS: %w", zone, err)
+		}
+		t.LogDnssec("zone %s : matches DS parent", zone)
+	}
+	// ------ END DS DIGEST VALIDATION ------
 
-		if dsResp.IsEmpty() {
-			// check presence of NSEC3
-			if err := VerifyNsec3(dsResp, parentDnsKeyResp); err != nil {
-				return fmt.Errorf("zone: %s : invalid DS: invalid NSEC3: %s", zone, err.Error())
-			}
+	return dnsKeyResp, nil
+}
 
-			// We have proof of non-existence of the DS record
-			// hence there is nothing to verify.
-			return nil
+// VerifyDenial verifies the authenticated denial of existence of the question,
+// using the NSEC3 records of the authority section if any, the NSEC records otherwise.
+func VerifyDenial(m model.DnsMsg, parentDnsKeyResp model.DnsMsg, now time.Time) error {
+
+	if len(m.GetNSEC3()) > 0 {
+		if err := VerifyNsec3(m, parentDnsKeyResp, now); err != nil {
+			return fmt.Errorf("invalid NSEC3: %w", err)
 		}
+		return nil
+	}
 
-		if err := VerifySignature(parentDnsKeyResp, dsResp); err != nil {
-			return fmt.Errorf("zone: %s : invalid DS: %s", zone, err.Error())
+	if err := VerifyNsec(m, parentDnsKeyResp, now); err != nil {
+		return fmt.Errorf("invalid NSEC: %w", err)
+	}
+	return nil
+}
+
+// DenialIsDelegation tells if the denial of existence of a DS record proves an unsigned delegation:
+// the NSEC or NSEC3 record matching the name has the NS type,
+// or an opt-out NSEC3 record covers the next closer name of the name (RFC 5155 §8.6).
+func DenialIsDelegation(m model.DnsMsg) bool {
+
+	name := m.GetQuestion().Name
+
+	for _, v := range m.GetNSEC() {
+		if NsecMatches(v, name) && NsecHasType(v, dns.TypeNS) {
+			return true
 		}
-		t.LogDnssec("zone %s : %T : valid", zone, &dns.DS{})
-		// ------ END DS VALIDATION ------
+	}
 
-		// ------ BEGIN DS DIGEST VALIDATION ------
-		// generate DS from KSK and match against DS value
-		ds := dsResp.GetDS()
-		kk := dnsKeyResp.ByKeyTag(ds.KeyTag)
-		if err := VerifyDigest(kk, ds); err != nil {
-			return fmt.Errorf("zone: %s : unable to validate DS: %s", zone, err.Error())
+	nsec3, err := SupportedNsec3(m.GetNSEC3())
+	if err != nil {
+		return false
+	}
+
+	if v := Nsec3Matching(name, nsec3); v != nil {
+		return Nsec3HasType(v, dns.TypeNS)
+	}
+
+	_, cover, err := Nsec3ClosestEncloser(name, nsec3)
+	return err == nil && cover.Flags&nsec3OptOut != 0
+}
+
+// VerifyNsec3 verifies the authenticated denial of existence of the question with NSEC3 records (RFC 5155 §8).
+// The signatures are verified first, so that forged NSEC3 records can neither cost hashing iterations nor prove an insecure denial.
+func VerifyNsec3(m model.DnsMsg, parentDnsKeyResp model.DnsMsg, now time.Time) error {
+
+	if len(m.GetNSEC3()) == 0 {
+		return NewDnssecError(dns.ExtendedErrorCodeNSECMissing, "no NSEC3 record found")
+	}
+
+	// verify signatures of Authority Section
+	if err := VerifyAuthority(m, parentDnsKeyResp, now, dns.TypeNSEC3); err != nil {
+		return err
+	}
+
+	nsec3, err := SupportedNsec3(m.GetNSEC3())
+	if err != nil {
+		return err
+	}
+
+	q := m.GetQuestion()
+
+	if m.GetMsg().Rcode == dns.RcodeNameError {
+		return Nsec3ProvesNameError(q.Name, nsec3)
+	}
+	return Nsec3ProvesNoData(q.Name, q.Qtype, nsec3)
+}
+
+// SupportedNsec3 returns the NSEC3 records hashed with SHA-1, the records of an unknown hash algorithm are ignored (RFC 5155 §8.1).
+// The denial is insecure when none is left, insecure or bogus when the records are hashed with too many iterations (RFC 9276 §3.2).
+func SupportedNsec3(nsec3 []*dns.NSEC3) ([]*dns.NSEC3, error) {
+
+	policy := GetDnssecAlgorithmPolicy()
+
+	supported := make([]*dns.NSEC3, 0, len(nsec3))
+	for _, v := range nsec3 {
+		if v.Hash != dns.SHA1 {
+			continue
 		}
-		t.LogDnssec("zone %s : matches DS parent", zone)
-		// ------ END DS DIGEST VALIDATION ------
+		if err := policy.VerifyNsec3Iterations(v.Iterations); err != nil {
+			return nil, err
+		}
+		supported = append(supported, v)
+	}
+
+	if len(supported) == 0 {
+		return nil, fmt.Errorf("no NSEC3 record with a supported hash algorithm: %w", ErrInsecureDelegation)
+	}
+
+	return supported, nil
+}
+
+// Nsec3ProvesNameError checks that the NSEC3 records prove that name does not exist (RFC 5155 §8.4):
+// its closest encloser exists, neither the next closer name nor the wildcard of the encloser do.
+// The names of an opt-out span may be unsigned delegations, their denial is insecure.
+func Nsec3ProvesNameError(name string, nsec3 []*dns.NSEC3) error {
+
+	if Nsec3Matching(name, nsec3) != nil {
+		return fmt.Errorf("NSEC3 proves name exists: %s", name)
+	}
+
+	encloser, cover, err := Nsec3ClosestEncloser(name, nsec3)
+	if err != nil {
+		return err
+	}
+
+	wildCard := dns.Fqdn("*." + strings.TrimSuffix(encloser, "."))
+	if Nsec3Covering(wildCard, nsec3) == nil {
+		return fmt.Errorf("NSEC3 does not cover wildcard: %s", wildCard)
+	}
+	t.LogDnssec("NSEC3 covers wildcard: %s", wildCard)
+
+	if cover.Flags&nsec3OptOut != 0 {
+		return fmt.Errorf("NSEC3 opt-out span covers name: %s: %w", name, ErrInsecureDelegation)
 	}
 
 	return nil
 }
 
-func VerifyNsec3(m model.DnsMsg, parentDnsKeyResp model.DnsMsg) error {
+// Nsec3ProvesNoData checks that the NSEC3 records prove that name exists without any record of type dnsType (RFC 5155 §8.5 to §8.7):
+// the record matching the name, an opt-out span covering the unsigned delegation of a DS, or the record matching the wildcard of the closest encloser.
+func Nsec3ProvesNoData(name string, dnsType uint16, nsec3 []*dns.NSEC3) error {
 
-	nsec3 := m.GetNSEC3()
-	name := m.GetQuestion().Name
+	if v := Nsec3Matching(name, nsec3); v != nil {
+
+		if Nsec3HasType(v, dnsType) || Nsec3HasType(v, dns.TypeCNAME) {
+			return fmt.Errorf("NSEC3 proves type %s exists: %s", dns.TypeToString[dnsType], name)
+		}
+
+		// the DS record lives in the parent zone, the NSEC3 at the apex of the child zone can not deny it.
+		if dnsType == dns.TypeDS && Nsec3HasType(v, dns.TypeSOA) && name != "." {
+			return fmt.Errorf("NSEC3 of the child zone can not deny DS: %s", name)
+		}
+
+		// the parent side of a delegation is only authoritative for the DS record.
+		if dnsType != dns.TypeDS && Nsec3IsDelegation(v) {
+			return fmt.Errorf("NSEC3 of delegation can not deny type %s: %s", dns.TypeToString[dnsType], name)
+		}
+
+		t.LogDnssec("NSEC3 proves no data: %s %s", name, dns.TypeToString[dnsType])
+		return nil
+	}
+
+	encloser, cover, err := Nsec3ClosestEncloser(name, nsec3)
+	if err != nil {
+		return err
+	}
 
-	if len(nsec3) == 0 {
-		return fmt.Errorf("no NSEC3 record found")
+	if dnsType == dns.TypeDS && cover.Flags&nsec3OptOut != 0 {
+		t.LogDnssec("NSEC3 opt-out span covers DS: %s", name)
+		return nil
 	}
 
-	// An NSEC3 record that *matches* the "closest encloser".
-	encloser := FindClosestEncloser(0, ".", name, m.GetNSEC3())
-	if encloser == "" {
-		return fmt.Errorf(fmt.Sprintf("NSEC3 does not matche closest encloser: %s", encloser))
+	wildCard := dns.Fqdn("*." + strings.TrimSuffix(encloser, "."))
+	if v := Nsec3Matching(wildCard, nsec3); v != nil {
+		if Nsec3HasType(v, dnsType) || Nsec3HasType(v, dns.TypeCNAME) {
+			return fmt.Errorf("NSEC3 proves type %s exists: %s", dns.TypeToString[dnsType], wildCard)
+		}
+		t.LogDnssec("NSEC3 proves no data: %s %s", wildCard, dns.TypeToString[dnsType])
+		return nil
+	}
+
+	return fmt.Errorf("NSEC3 does not match name: %s", name)
+}
+
+// Nsec3ClosestEncloser returns the closest encloser of name, the longest existing ancestor matched by one of the NSEC3 records,
+// along with the NSEC3 record covering the next closer name, one label longer than the encloser (RFC 5155 §8.3).
+func Nsec3ClosestEncloser(name string, nsec3 []*dns.NSEC3) (string, *dns.NSEC3, error) {
+
+	for deep := dns.CountLabel(name) - 1; deep >= 0; deep-- {
+
+		encloser := h.SubZone(name, deep)
+		match := Nsec3Matching(encloser, nsec3)
+		if match == nil {
+			continue
+		}
+		t.LogDnssec("NSEC3 matches closest encloser: %s", encloser)
+
+		// the names below a delegation, or a DNAME, are not part of the zone.
+		if Nsec3IsDelegation(match) {
+			return "", nil, fmt.Errorf("NSEC3 of delegation %s can not deny name: %s", encloser, name)
+		}
+
+		nextCloser := h.SubZone(name, deep+1)
+		cover := Nsec3Covering(nextCloser, nsec3)
+		if cover == nil {
+			return "", nil, fmt.Errorf("NSEC3 does not cover next closer name: %s", nextCloser)
+		}
+		t.LogDnssec("NSEC3 covers next closer name: %s", nextCloser)
+
+		return encloser, cover, nil
+	}
+
+	return "", nil, fmt.Errorf("NSEC3 does not match closest encloser: %s", name)
+}
+
+func Nsec3Matching(name string, nsec3 []*dns.NSEC3) *dns.NSEC3 {
+	for _, v := range nsec3 {
+		if v.Match(name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Nsec3Covering returns the NSEC3 record whose hashes sort strictly around the hash of name,
+// miekg/dns also tells that the owner of the record covers itself.
+func Nsec3Covering(name string, nsec3 []*dns.NSEC3) *dns.NSEC3 {
+	for _, v := range nsec3 {
+		if v.Cover(name) && !v.Match(name) {
+			return v
+		}
 	}
-	t.LogDnssec(fmt.Sprintf("NSEC3 matches closest encloser: %s", encloser))
+	return nil
+}
 
-	// An NSEC3 record that *covers* the "next closer name".
-	{
-		if !Nsec3Covers(name, nsec3) {
-			return fmt.Errorf(fmt.Sprintf("NSEC3 does not cover next closer name: %s", encloser))
+func Nsec3Covers(name string, nsec3 []*dns.NSEC3) bool {
+	return Nsec3Covering(name, nsec3) != nil
+}
+
+func Nsec3HasType(nsec3 *dns.NSEC3, dnsType uint16) bool {
+	for _, v := range nsec3.TypeBitMap {
+		if v == dnsType {
+			return true
 		}
-		t.LogDnssec(fmt.Sprintf("NSEC3 covers next closer name: %s", name))
 	}
+	return false
+}
+
+// Nsec3IsDelegation tells if the NSEC3 record belongs to a zone cut seen from the parent zone, or to a DNAME.
+func Nsec3IsDelegation(nsec3 *dns.NSEC3) bool {
+	return (Nsec3HasType(nsec3, dns.TypeNS) && !Nsec3HasType(nsec3, dns.TypeSOA)) || Nsec3HasType(nsec3, dns.TypeDNAME)
+}
+
+// VerifyNsec verifies the authenticated denial of existence of the question with NSEC records (RFC 4035 §5.4).
+// A NXDOMAIN response must prove that neither the name nor a matching wildcard exists,
+// a NODATA response must prove that the name exists without the requested type.
+func VerifyNsec(m model.DnsMsg, parentDnsKeyResp model.DnsMsg, now time.Time) error {
 
-	// "*.<closest encloser>"
-	{
-		wildCard := "*." + encloser
-		if len(nsec3) > 2 && !Nsec3Covers(wildCard, nsec3) {
-			return fmt.Errorf(fmt.Sprintf("NSEC3 does not covers wildcard: %s", wildCard))
+	nsec := m.GetNSEC()
+	if len(nsec) == 0 {
+		return NewDnssecError(dns.ExtendedErrorCodeNSECMissing, "no NSEC record found")
+	}
+
+	q := m.GetQuestion()
+
+	if m.GetMsg().Rcode == dns.RcodeNameError {
+		if err := NsecProvesNameError(q.Name, nsec); err != nil {
+			return err
+		}
+	} else {
+		if err := NsecProvesNoData(q.Name, q.Qtype, nsec); err != nil {
+			return err
 		}
-		t.LogDnssec(fmt.Sprintf("NSEC3 covers wildcard: %s", wildCard))
 	}
 
 	// verify signatures of Authority Section
-	var currentRR dns.RR
-	for _, v := range m.GetMsg().Ns {
+	return VerifyAuthority(m, parentDnsKeyResp, now, dns.TypeNSEC)
+}
+
+// NsecProvesNameError checks that the NSEC records prove that name does not exist,
+// and that no wildcard of its closest encloser could have been expanded.
+func NsecProvesNameError(name string, nsec []*dns.NSEC) error {
+
+	cover := NsecCovering(name, nsec)
+	if cover == nil {
+		return fmt.Errorf("NSEC does not cover name: %s", name)
+	}
+	t.LogDnssec("NSEC covers name: %s", name)
+
+	// the next name is a descendant: name is an empty non-terminal, it exists.
+	if dns.IsSubDomain(name, cover.NextDomain) {
+		return fmt.Errorf("NSEC proves name is an empty non-terminal: %s", name)
+	}
 
-		if rrsig, ok := v.(*dns.RRSIG); ok {
-			kk := parentDnsKeyResp.ByKeyTag(rrsig.KeyTag)
+	// an NSEC of a delegation, or of a DNAME, of the parent zone does not deny the names below it.
+	for _, v := range nsec {
+		if dns.IsSubDomain(v.Hdr.Name, name) && NsecIsDelegation(v) {
+			return fmt.Errorf("NSEC of delegation %s can not deny name: %s", v.Hdr.Name, name)
+		}
+	}
+
+	// the closest encloser is the longest ancestor shared with the names of the covering NSEC.
+	encloser := h.CommonAncestor(name, cover.Hdr.Name)
+	if next := h.CommonAncestor(name, cover.NextDomain); dns.CountLabel(next) > dns.CountLabel(encloser) {
+		encloser = next
+	}
+	t.LogDnssec("NSEC closest encloser: %s", encloser)
 
-			if err := VerifySig(kk, rrsig, []dns.RR{currentRR}); err != nil {
-				return fmt.Errorf("invalid key found: %s", err.Error())
+	wildCard := dns.Fqdn("*." + strings.TrimSuffix(encloser, "."))
+	if NsecCovering(wildCard, nsec) == nil {
+		return fmt.Errorf("NSEC does not cover wildcard: %s", wildCard)
+	}
+	t.LogDnssec("NSEC covers wildcard: %s", wildCard)
+
+	return nil
+}
+
+// NsecProvesNoData checks that the NSEC records prove that name exists without any record of type dnsType.
+func NsecProvesNoData(name string, dnsType uint16, nsec []*dns.NSEC) error {
+
+	for _, v := range nsec {
+
+		if NsecMatches(v, name) {
+
+			if NsecHasType(v, dnsType) || NsecHasType(v, dns.TypeCNAME) {
+				return fmt.Errorf("NSEC proves type %s exists: %s", dns.TypeToString[dnsType], name)
 			}
-			continue
+
+			// the DS record lives in the parent zone, the NSEC at the apex of the child zone can not deny it.
+			if dnsType == dns.TypeDS && NsecHasType(v, dns.TypeSOA) && name != "." {
+				return fmt.Errorf("NSEC of the child zone can not deny DS: %s", name)
+			}
+
+			// the parent side of a delegation is only authoritative for the DS record.
+			if dnsType != dns.TypeDS && NsecIsDelegation(v) {
+				return fmt.Errorf("NSEC of delegation can not deny type %s: %s", dns.TypeToString[dnsType], name)
+			}
+
+			t.LogDnssec("NSEC proves no data: %s %s", name, dns.TypeToString[dnsType])
+			return nil
 		}
 
-		currentRR = v
+		// empty non-terminal: name owns no record but has descendants.
+		if NsecCovers(v, name) && dns.IsSubDomain(name, v.NextDomain) {
+			t.LogDnssec("NSEC proves empty non-terminal: %s", name)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("NSEC does not match name: %s", name)
+}
+
+// NsecMatches tells if the owner name of the NSEC record is name.
+func NsecMatches(nsec *dns.NSEC, name string) bool {
+	return h.CanonicalCompare(nsec.Hdr.Name, name) == 0
+}
+
+// NsecCovers tells if name sorts strictly between the owner name and the next name of the NSEC record.
+func NsecCovers(nsec *dns.NSEC, name string) bool {
+
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+
+	if h.CanonicalCompare(owner, name) >= 0 {
+		return false
 	}
 
+	// the last NSEC of a zone points back to its apex.
+	if h.CanonicalCompare(owner, next) >= 0 {
+		return dns.IsSubDomain(next, name)
+	}
+
+	return h.CanonicalCompare(name, next) < 0
+}
+
+func NsecCovering(name string, nsec []*dns.NSEC) *dns.NSEC {
+	for _, v := range nsec {
+		if NsecCovers(v, name) {
+			return v
+		}
+	}
 	return nil
 }
 
-func Nsec3Covers(name string, nsec3 []*dns.NSEC3) bool {
-	for _, v := range nsec3 {
-		if v.Cover(name) {
+func NsecHasType(nsec *dns.NSEC, dnsType uint16) bool {
+	for _, v := range nsec.TypeBitMap {
+		if v == dnsType {
 			return true
 		}
 	}
 	return false
 }
 
-func FindClosestEncloser(deep int, zone, initial string, nsec3 []*dns.NSEC3) string {
+// NsecIsDelegation tells if the NSEC record belongs to a zone cut seen from the parent zone, or to a DNAME.
+func NsecIsDelegation(nsec *dns.NSEC) bool {
+	return (NsecHasType(nsec, dns.TypeNS) && !NsecHasType(nsec, dns.TypeSOA)) || NsecHasType(nsec, dns.TypeDNAME)
+}
+
+// VerifyAuthority verifies the signatures of the RRsets of the authority section,
+// the RRsets of the specified types must be signed.
+func VerifyAuthority(m model.DnsMsg, keys model.DnsMsg, now time.Time, signed ...uint16) error {
+
+	for _, rrset := range model.NewDnsRRsets(m.GetMsg().Ns) {
+
+		if len(rrset.Signatures) == 0 {
+			for _, v := range signed {
+				if v == rrset.Type {
+					return NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no signature: %s", rrset)
+				}
+			}
+			continue
+		}
+
+		if err := VerifyRRset(keys, rrset, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyRRset verifies the signatures of the RRset with the specified DNSKEY set:
+// one valid signature of a supported algorithm is enough, the others may be made with retired keys (RFC 4035 §5.3.3).
+func VerifyRRset(keys model.DnsMsg, rrset model.DnsRRset, now time.Time) error {
 
-	if len(nsec3) > 0 && nsec3[0].Match(zone) {
-		return zone
+	if len(rrset.Signatures) == 0 {
+		return NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no signature: %s", rrset)
 	}
 
-	if zone == initial {
-		return ""
+	supported := SupportedSignatures(rrset.Signatures)
+	if len(supported) == 0 {
+		return fmt.Errorf("invalid key found: %s: %w", rrset, VerifyUnsupportedSignatures(keys, rrset.Signatures))
 	}
 
-	subZone := h.SubZone(initial, deep+1)
-	return FindClosestEncloser(deep+1, subZone, initial, nsec3)
+	var first error
+	others := make([]string, 0, len(supported))
+
+	for _, rrsig := range supported {
+		kk := keys.ByKeyTag(rrsig.KeyTag)
+		err := VerifySig(kk, rrsig, rrset.RR, now)
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		} else {
+			others = append(others, err.Error())
+		}
+	}
+
+	// the extended error of the first signature is reported, along with the errors of the others.
+	if len(others) > 0 {
+		return fmt.Errorf("invalid key found: %s: [%s]: %w", rrset, strings.Join(others, "] ["), first)
+	}
+	return fmt.Errorf("invalid key found: %s: %w", rrset, first)
 }
 
-// VerifyTrustAnchors compares DNSKEY(s) with the specified trust anchors
-func VerifyTrustAnchors(m model.DnsMsg, anchors []model.IanaKeyDigest) error {
+// VerifyTrustAnchors verifies that one of the trust anchors matches a key which signs the DNSKEY set.
+func VerifyTrustAnchors(m model.DnsMsg, anchors []model.IanaKeyDigest, now time.Time) error {
+
+	signers := SelfSignedKeys(m, now)
 
 	for _, a := range anchors {
-		for _, rrsig := range m.GetRRSIG() {
+		ds := a.ToDS()
+		for _, ksk := range signers {
+			if VerifyDigest(ksk, ds) == nil {
+				return nil
+			}
+		}
+	}
+
+	return NewDnssecError(dns.ExtendedErrorCodeDNSKEYMissing, "no key matching a trust anchor signs the DNSKEY set")
+}
+
+// VerifyDelegation verifies that one of the DS records matches a key which signs the DNSKEY set,
+// a key added to the set without being signed by the delegated key is not trusted.
+func VerifyDelegation(keys model.DnsMsg, ds []*dns.DS, now time.Time) error {
 
-			ksk := m.ByKeyTag(rrsig.KeyTag)
-			ds := a.ToDS()
+	signers := SelfSignedKeys(keys, now)
 
-			if err := VerifyDigest(ksk, ds); err == nil {
+	for _, v := range ds {
+		for _, ksk := range signers {
+			if ksk.KeyTag() == v.KeyTag && ksk.Algorithm == v.Algorithm && VerifyDigest(ksk, v) == nil {
 				return nil
 			}
 		}
 	}
 
-	return fmt.Errorf("unable to match trust anchor")
+	return NewDnssecError(dns.ExtendedErrorCodeDNSKEYMissing, "no key matching a DS signs the DNSKEY set")
+}
+
+// SelfSignedKeys returns the keys of the DNSKEY set whose signature over the set verifies.
+func SelfSignedKeys(m model.DnsMsg, now time.Time) []*dns.DNSKEY {
+
+	signers := make([]*dns.DNSKEY, 0, 2)
+	for _, key := range m.GetDNSKEY() {
+		if IsSelfSigned(m, key, now) {
+			signers = append(signers, key)
+		}
+	}
+
+	return signers
 }
 
 // VerifyDigest calculates the DS digest of the specified key and compares with the specified digest
@@ -321,32 +1147,67 @@ func VerifyDigest(ksk *dns.DNSKEY, ds *dns.DS) error {
 	return nil
 }
 
-func VerifySignature(keys model.DnsMsg, m model.DnsMsg) error {
+// VerifySignature verifies every RRset of the answer section with the specified DNSKEY set.
+func VerifySignature(keys model.DnsMsg, m model.DnsMsg, now time.Time) error {
 
 	if m.IsEmpty() {
 		return fmt.Errorf("answer is empty: %s", m)
 	}
 
-	signatures := m.GetRRSIG()
-	if len(signatures) == 0 {
-		return fmt.Errorf("no signature: %s", m)
+	if len(m.GetRRSIG()) == 0 {
+		return NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no signature: %s", m)
+	}
+
+	// every RRset of the answer must be signed, not only the one of the question.
+	for _, rrset := range model.NewDnsRRsets(m.GetMsg().Answer) {
+		if err := VerifyRRset(keys, rrset, now); err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// SupportedSignatures ignores the signatures made with an algorithm which is not supported.
+func SupportedSignatures(signatures []*dns.RRSIG) []*dns.RRSIG {
+
+	policy := GetDnssecAlgorithmPolicy()
+
+	supported := make([]*dns.RRSIG, 0, len(signatures))
 	for _, rrsig := range signatures {
+		if policy.Algorithm(rrsig.Algorithm) == DnssecSupported {
+			supported = append(supported, rrsig)
+		}
+	}
 
-		kk := keys.ByKeyTag(rrsig.KeyTag)
-		rr := m.GetRR()
+	return supported
+}
+
+// VerifyUnsupportedSignatures returns the error of an RRset signed with algorithms which are not supported only:
+// bogus when one of them is rejected, or when the DNSKEY set holds a key of a supported algorithm,
+// as the RRset must then be signed with it as well (RFC 4035 §2.2), insecure otherwise.
+func VerifyUnsupportedSignatures(keys model.DnsMsg, signatures []*dns.RRSIG) error {
 
-		if err := VerifySig(kk, rrsig, rr); err != nil {
-			return fmt.Errorf("invalid key found: %s", err.Error())
+	policy := GetDnssecAlgorithmPolicy()
+
+	var err error
+	for _, rrsig := range signatures {
+		if err = policy.VerifyAlgorithm(rrsig.Algorithm); !errors.Is(err, ErrInsecureDelegation) {
+			return err
 		}
 	}
 
-	return nil
+	for _, key := range keys.GetDNSKEY() {
+		if policy.Algorithm(key.Algorithm) == DnssecSupported {
+			return NewDnssecError(dns.ExtendedErrorCodeRRSIGsMissing, "no signature made with the %s algorithm", algorithmName(key.Algorithm))
+		}
+	}
+
+	return err
 }
 
 // VerifySig verifies signature of the given RRSET, RRSIG against the specified DNSKEY set
-func VerifySig(ksk *dns.DNSKEY, rrsig *dns.RRSIG, rrset []dns.RR) error {
+func VerifySig(ksk *dns.DNSKEY, rrsig *dns.RRSIG, rrset []dns.RR, now time.Time) error {
 
 	if len(rrset) == 0 {
 		return fmt.Errorf("must provide rrset")
@@ -357,17 +1218,24 @@ func VerifySig(ksk *dns.DNSKEY, rrsig *dns.RRSIG, rrset []dns.RR) error {
 	}
 
 	if ksk == nil {
-		return fmt.Errorf("must provide ksk")
+		return NewDnssecError(dns.ExtendedErrorCodeDNSKEYMissing, "no DNSKEY matches keyTag: %d", rrsig.KeyTag)
+	}
+
+	if err := GetDnssecAlgorithmPolicy().VerifyAlgorithm(rrsig.Algorithm); err != nil {
+		return fmt.Errorf("invalid RRSIG: keyTag: %d: %w", rrsig.KeyTag, err)
 	}
 
 	// verify rrset signature against the key
 	err := rrsig.Verify(ksk, rrset)
 	if err != nil {
-		return fmt.Errorf("invalid RRSIG: keyTag: %d: %s", rrsig.KeyTag, err.Error())
+		return fmt.Errorf("invalid RRSIG: keyTag: %d: %w", rrsig.KeyTag, err)
 	}
 
-	if !rrsig.ValidityPeriod(time.Now()) {
-		return fmt.Errorf("invalid RRSIG period: keyTag: %d", rrsig.KeyTag)
+	if !rrsig.ValidityPeriod(now) {
+		if now.Unix() > int64(rrsig.Expiration) {
+			return NewDnssecError(dns.ExtendedErrorCodeSignatureExpired, "invalid RRSIG period: keyTag: %d", rrsig.KeyTag)
+		}
+		return NewDnssecError(dns.ExtendedErrorCodeSignatureNotYetValid, "invalid RRSIG period: keyTag: %d", rrsig.KeyTag)
 	}
 
 	return nil
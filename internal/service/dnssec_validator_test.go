@@ -1,110 +1,547 @@
 package service
 
 import (
+	"crypto"
 	_ "embed"
+	"errors"
 	"github.com/miekg/dns"
-	"golang-dns/internal/service/conf"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
 	"golang-dns/internal/transverse"
 	"net"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed conf/dns/fake-anchors.xml
 var FakeAnchorsFile string
 
-func NewDnsSecResolver() DnsResolverProxy {
-	return NewDnsResolverRestyImpl(NewHardenedResty("dns.google", conf.GoogleCertFile, net.IPv4(8, 8, 8, 8)), "https://8.8.8.8/dns-query")
+// NewDnssecFixtureResolver serves the recorded responses of the synthetic zones of testdata/dnssec.
+func NewDnssecFixtureResolver(t *testing.T) DnsResolverProxy {
+	fixtures, err := model.NewDnsFixturesFromBytes(DnssecFixturesFile)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	proxy, err := NewDnsResolverFixture(fixtures)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return proxy
 }
 
 func TestDnssecValid(t *testing.T) {
 
 	transverse.SetTest()
 
+	proxy := NewDnssecFixtureResolver(t)
+	validator := NewDnssecValidatorFromIanaFile(proxy, LoadIanaFile(DnssecFixturesAnchorsFile)).WithClock(transverse.FixedClock(DnssecFixturesTime))
+	resolver := proxy.AsResolver()
+
+	for _, tt := range DnssecFixtureQuestions {
+		r, err := resolver.Query(tt.name, tt.dnsType)
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+
+		r, err = validator.Validate(r)
+		if err != nil {
+			t.Fatalf("%s %s: received error: %v", tt.name, dns.TypeToString[tt.dnsType], err.Error())
+		}
+		if status := r.GetDnssecResult().Status; status != tt.status {
+			t.Fatalf("%s %s: expect %s, got %s", tt.name, dns.TypeToString[tt.dnsType], tt.status, status)
+		}
+
+		t.Logf("received %v %v", r.GetMsg().Answer, r.GetMsg().Ns)
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecInvalid(t *testing.T) {
+
+	transverse.SetTest()
+
+	tamperRecord := func(m *dns.Msg) {
+		m.Answer[0].(*dns.A).A = net.IPv4(127, 0, 0, 1)
+	}
+	tamperSignature := func(m *dns.Msg) {
+		rrsig := m.Answer[1].(*dns.RRSIG)
+		rrsig.Signature = "A" + rrsig.Signature[1:]
+	}
+
 	tests := []struct {
 		name    string
-		dnsType uint16
+		anchors string
+		now     time.Time
+		tamper  func(m *dns.Msg)
+		ede     uint16
 	}{
-		{"afnic.fr", dns.TypeSOA},
-		{"afnic.fr", dns.TypeA},
-		{"afnic.fr", dns.TypeMX},
-		{"afnic.fr", dns.TypeTXT},
-		{"icourrier.fr", dns.TypeMX},
-		{"_dmarc.icourrier.fr", dns.TypeTXT},
-		{"chrome.cloudflare-dns.com.", dns.TypeA},
-		{"protonvpn.ch.", dns.TypeA},
-		{"client.dropbox.com.", dns.TypeA},
-		{"api.dropboxapi.com.", dns.TypeA},
-		{"dns.google", dns.TypeA},
-		{"cloudflare-dns.com", dns.TypeA},
-		{"quad9.net.", dns.TypeA},
-		{"paypal.com.", dns.TypeA},
-		{"dns.quad9.net.", dns.TypeA},
-		//{"ocsp.pki.goog.", dns.TypeA},
-	}
-
-	proxy := NewDnsSecResolver()
-	validator := NewDnssecValidator(proxy)
+		{"fake anchors", FakeAnchorsFile, DnssecFixturesTime, nil, dns.ExtendedErrorCodeDNSKEYMissing},
+		{"tampered record", DnssecFixturesAnchorsFile, DnssecFixturesTime, tamperRecord, dns.ExtendedErrorCodeDNSBogus},
+		{"tampered signature", DnssecFixturesAnchorsFile, DnssecFixturesTime, tamperSignature, dns.ExtendedErrorCodeDNSBogus},
+		{"expired signature", DnssecFixturesAnchorsFile, DnssecFixturesExpiration.Add(time.Hour), nil, dns.ExtendedErrorCodeSignatureExpired},
+		{"signature not yet valid", DnssecFixturesAnchorsFile, DnssecFixturesInception.Add(-time.Hour), nil, dns.ExtendedErrorCodeSignatureNotYetValid},
+	}
+
+	proxy := NewDnssecFixtureResolver(t)
 	resolver := proxy.AsResolver()
 
 	for _, tt := range tests {
-		r, err := resolver.Query(tt.name, tt.dnsType)
+
+		validator := NewDnssecValidatorFromIanaFile(proxy, LoadIanaFile(tt.anchors)).WithClock(transverse.FixedClock(tt.now))
+
+		r, err := resolver.Query("afnic.fr.", dns.TypeA)
 		if err != nil {
 			t.Fatalf("received error: %v", err.Error())
 		}
-		if len(r.GetRR()) < 1 {
-			t.Fatalf("RR not present")
+		if tt.tamper != nil {
+			tt.tamper(r.GetMsg())
+		}
+
+		r, err = validator.Validate(r)
+		if err == nil {
+			t.Fatalf("%s: not received any error", tt.name)
 		}
-		if r.GetRRSIG() == nil {
-			t.Fatalf("RRSIG not present")
+
+		result := r.GetDnssecResult()
+		if result.Status != model.DnssecBogus || result.Ede.InfoCode != tt.ede {
+			t.Fatalf("%s: expect bogus with EDE %d, got %s", tt.name, tt.ede, result)
 		}
 
-		err = validator.Verify(r)
+		t.Logf("received error: %v", err.Error())
+	}
+
+	t.Logf("Success !")
+}
+
+func TestDnssecKeyInjection(t *testing.T) {
+
+	transverse.SetTest()
+
+	fixtures, err := model.NewDnsFixturesFromBytes(DnssecFixturesFile)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	// an attacker adds its key to the DNSKEY set of afnic.fr., signs the set and a forged answer with it.
+	attacker, signer := NewTestZoneKey(t, "afnic.fr.")
+	sign := func(rrset ...dns.RR) []dns.RR {
+		return append(rrset, SignTestRRsetPeriod(t, attacker, signer, DnssecFixturesInception, DnssecFixturesExpiration, rrset...))
+	}
+
+	for i, f := range fixtures {
+		if f.Key() != model.DnsFixtureKey("afnic.fr.", dns.TypeDNSKEY) {
+			continue
+		}
+		m, err := f.Msg()
 		if err != nil {
 			t.Fatalf("received error: %v", err.Error())
 		}
+		keys := append(h.CollectAll(m.Answer, dns.TypeDNSKEY), attacker)
+		m.Answer = append(sign(keys...), h.CollectAll(m.Answer, dns.TypeRRSIG)...)
+		fixtures[i] = model.NewDnsFixture(model.NewDnsMsg(m))
+	}
 
-		t.Logf("received %v", r.GetMsg().Answer)
+	proxy, err := NewDnsResolverFixture(fixtures)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
 	}
+	validator := NewDnssecValidatorFromIanaFile(proxy, LoadIanaFile(DnssecFixturesAnchorsFile)).WithClock(transverse.FixedClock(DnssecFixturesTime))
+
+	m := h.Msg("afnic.fr.", dns.TypeA, dns.ClassINET)
+	m.Answer = sign(MustRR(t, "afnic.fr. 3600 IN A 6.6.6.6"))
 
+	r, err := validator.Validate(model.NewDnsMsg(m))
+	if err == nil {
+		t.Fatalf("not received any error")
+	}
+
+	result := r.GetDnssecResult()
+	if result.Status != model.DnssecBogus || result.Ede.InfoCode != dns.ExtendedErrorCodeDNSKEYMissing {
+		t.Fatalf("expect bogus with EDE %d, got %s", dns.ExtendedErrorCodeDNSKEYMissing, result)
+	}
+
+	t.Logf("received error: %v", err.Error())
 	t.Logf("Success !")
 }
 
-func TestDnssecInvalid(t *testing.T) {
+func TestVerifyRRsetSignatures(t *testing.T) {
+
+	transverse.SetTest()
+
+	key, signer := NewTestZoneKey(t, "example.")
+	retired, retiredSigner := NewTestZoneKey(t, "example.")
+	keys := model.NewDnsMsg(&dns.Msg{Answer: []dns.RR{key}})
+	rr := MustRR(t, "www.example. 3600 IN A 127.0.0.1")
+
+	valid := SignTestRRset(t, key, signer, rr)
+	expired := SignTestRRsetPeriod(t, key, signer, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), rr)
+	unknown := SignTestRRset(t, retired, retiredSigner, rr)
+
+	tests := []struct {
+		name       string
+		signatures []*dns.RRSIG
+		status     model.DnssecStatus
+		ede        uint16
+	}{
+		{"valid", []*dns.RRSIG{valid}, model.DnssecSecure, 0},
+		{"retired key and valid", []*dns.RRSIG{unknown, valid}, model.DnssecSecure, 0},
+		{"expired and valid", []*dns.RRSIG{expired, valid}, model.DnssecSecure, 0},
+		{"expired and retired key", []*dns.RRSIG{expired, unknown}, model.DnssecBogus, dns.ExtendedErrorCodeSignatureExpired},
+		{"retired key", []*dns.RRSIG{unknown}, model.DnssecBogus, dns.ExtendedErrorCodeDNSKEYMissing},
+	}
+
+	for _, tt := range tests {
+
+		rrset := model.DnsRRset{Name: "www.example.", Type: dns.TypeA, RR: []dns.RR{rr}, Signatures: tt.signatures}
+		result := NewDnssecResult(VerifyRRset(keys, rrset, time.Now()))
+
+		if result.Status != tt.status {
+			t.Fatalf("%s: expected %s, received %s", tt.name, tt.status, result)
+		}
+		if tt.ede != 0 && (result.Ede == nil || result.Ede.InfoCode != tt.ede) {
+			t.Fatalf("%s: expected EDE %d, received %s", tt.name, tt.ede, result)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestVerifyDelegationSigner(t *testing.T) {
+
+	transverse.SetTest()
+
+	ksk, signer := NewTestZoneKey(t, "example.")
+	unsigned, _ := NewTestZoneKey(t, "example.")
+	m := h.Msg("example.", dns.TypeDNSKEY, dns.ClassINET)
+	m.Answer = []dns.RR{ksk, unsigned, SignTestRRset(t, ksk, signer, ksk, unsigned)}
+	keys := model.NewDnsMsg(m)
+
+	digest := func(key *dns.DNSKEY) *dns.DS {
+		ds := key.ToDS(dns.SHA256)
+		ds.Hdr = dns.RR_Header{Name: "example.", Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+		return ds
+	}
+	anchor := func(key *dns.DNSKEY) []model.IanaKeyDigest {
+		ds := digest(key)
+		return []model.IanaKeyDigest{{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: ds.Digest}}
+	}
+
+	tests := []struct {
+		name  string
+		key   *dns.DNSKEY
+		valid bool
+	}{
+		{"key signing the set", ksk, true},
+		{"key not signing the set", unsigned, false},
+	}
+
+	for _, tt := range tests {
+
+		errs := []error{
+			VerifyDelegation(keys, []*dns.DS{digest(tt.key)}, time.Now()),
+			VerifyTrustAnchors(keys, anchor(tt.key), time.Now()),
+		}
+
+		for _, err := range errs {
+			if tt.valid && err != nil {
+				t.Fatalf("%s: received error: %v", tt.name, err.Error())
+			}
+			if result := NewDnssecResult(err); !tt.valid && (result.Ede == nil || result.Ede.InfoCode != dns.ExtendedErrorCodeDNSKEYMissing) {
+				t.Fatalf("%s: expected a missing DNSKEY error, received %s", tt.name, result)
+			}
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+// NewTestZoneKey generates a signing key for zone.
+func NewTestZoneKey(t *testing.T, zone string) (*dns.DNSKEY, crypto.Signer) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return key, priv.(crypto.Signer)
+}
+
+// SignTestRRset returns the RRSIG of rrset made with the key of zone.
+func SignTestRRset(t *testing.T, key *dns.DNSKEY, signer crypto.Signer, rrset ...dns.RR) *dns.RRSIG {
+	return SignTestRRsetPeriod(t, key, signer, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), rrset...)
+}
+
+// SignTestRRsetPeriod returns the RRSIG of rrset valid from inception to expiration.
+func SignTestRRsetPeriod(t *testing.T, key *dns.DNSKEY, signer crypto.Signer, inception, expiration time.Time, rrset ...dns.RR) *dns.RRSIG {
+	hdr := rrset[0].Header()
+	labels := dns.CountLabel(hdr.Name)
+	if strings.HasPrefix(hdr.Name, "*.") {
+		labels-- // the "*" label of a wildcard is not counted
+	}
+	rrsig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+		TypeCovered: hdr.Rrtype,
+		Algorithm:   key.Algorithm,
+		Labels:      uint8(labels),
+		OrigTtl:     hdr.Ttl,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      key.KeyTag(),
+		SignerName:  key.Hdr.Name,
+	}
+	if err := rrsig.Sign(signer, rrset); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return rrsig
+}
+
+func MustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	return rr
+}
+
+func TestCanonicalCompare(t *testing.T) {
+
+	// RFC 4034 §6.1
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example."}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		if h.CanonicalCompare(ordered[i], ordered[i+1]) >= 0 {
+			t.Fatalf("%s must sort before %s", ordered[i], ordered[i+1])
+		}
+		if h.CanonicalCompare(ordered[i+1], ordered[i]) <= 0 {
+			t.Fatalf("%s must sort after %s", ordered[i+1], ordered[i])
+		}
+	}
+
+	if h.CanonicalCompare("Example.", "example.") != 0 {
+		t.Fatalf("comparison must be case insensitive")
+	}
+
+	t.Logf("Success !")
+}
+
+func TestNsecDenial(t *testing.T) {
 
 	transverse.SetTest()
 
+	// zone example. contains: example. a.example. b.c.example. (c.example. is an empty non-terminal) sub.example. (delegation)
+	nsec := []*dns.NSEC{
+		MustRR(t, "example. 3600 IN NSEC a.example. SOA NS RRSIG NSEC DNSKEY").(*dns.NSEC),
+		MustRR(t, "a.example. 3600 IN NSEC b.c.example. A RRSIG NSEC").(*dns.NSEC),
+		MustRR(t, "b.c.example. 3600 IN NSEC sub.example. TXT RRSIG NSEC").(*dns.NSEC),
+		MustRR(t, "sub.example. 3600 IN NSEC example. NS RRSIG NSEC").(*dns.NSEC),
+	}
+
 	tests := []struct {
 		name    string
+		rcode   int
 		dnsType uint16
+		valid   bool
 	}{
-		{"afnic.fr", dns.TypeSOA},
-		{"afnic.fr", dns.TypeA},
-		{"afnic.fr", dns.TypeMX},
-		{"afnic.fr", dns.TypeTXT},
+		{"zzz.example.", dns.RcodeNameError, dns.TypeA, true},
+		{"aa.example.", dns.RcodeNameError, dns.TypeA, true},
+		{"c.example.", dns.RcodeNameError, dns.TypeA, false},     // empty non-terminal
+		{"a.example.", dns.RcodeNameError, dns.TypeA, false},     // exists
+		{"x.sub.example.", dns.RcodeNameError, dns.TypeA, false}, // below a delegation
+		{"a.example.", dns.RcodeSuccess, dns.TypeMX, true},
+		{"a.example.", dns.RcodeSuccess, dns.TypeA, false},
+		{"c.example.", dns.RcodeSuccess, dns.TypeA, true},    // empty non-terminal
+		{"sub.example.", dns.RcodeSuccess, dns.TypeDS, true}, // insecure delegation
+		{"sub.example.", dns.RcodeSuccess, dns.TypeA, false},
+		{"example.", dns.RcodeSuccess, dns.TypeDS, false}, // child side of the zone cut
+		{"zzz.example.", dns.RcodeSuccess, dns.TypeA, false},
 	}
 
-	proxy := NewDnsSecResolver()
-	validator := NewDnssecValidatorFromIanaFile(proxy, LoadIanaFile(FakeAnchorsFile)) // fake anchors
-	resolver := proxy.AsResolver()
+	for _, tt := range tests {
+
+		var err error
+		if tt.rcode == dns.RcodeNameError {
+			err = NsecProvesNameError(tt.name, nsec)
+		} else {
+			err = NsecProvesNoData(tt.name, tt.dnsType, nsec)
+		}
+
+		if tt.valid && err != nil {
+			t.Fatalf("%s %s: received error: %v", tt.name, dns.TypeToString[tt.dnsType], err.Error())
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s %s: not received any error", tt.name, dns.TypeToString[tt.dnsType])
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+// NewTestNsec3Chain hashes the names of the zone into a chain of NSEC3 records, each listing the types of its name.
+func NewTestNsec3Chain(zone string, optOut bool, iterations uint16, names map[string][]uint16) []*dns.NSEC3 {
+
+	hashes := make([]string, 0, len(names))
+	types := make(map[string][]uint16, len(names))
+	for name, v := range names {
+		hash := dns.HashName(name, dns.SHA1, iterations, "")
+		hashes = append(hashes, hash)
+		types[hash] = append(v, dns.TypeRRSIG)
+		sort.Slice(types[hash], func(i, j int) bool { return types[hash][i] < types[hash][j] })
+	}
+	sort.Strings(hashes)
+
+	var flags uint8
+	if optOut {
+		flags = nsec3OptOut
+	}
+
+	chain := make([]*dns.NSEC3, 0, len(hashes))
+	for i, hash := range hashes {
+		chain = append(chain, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			Iterations: iterations,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: types[hash],
+		})
+	}
+
+	return chain
+}
+
+func TestNsec3Denial(t *testing.T) {
+
+	transverse.SetTest()
+
+	// zone example. contains: example. a.example. b.c.example. (c.example. is an empty non-terminal) sub.example. (delegation)
+	// *.w.example. (w.example. is an empty non-terminal)
+	// the opt-out chain omits the unsigned delegation unsigned.example.
+	names := map[string][]uint16{
+		"example.":     {dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"a.example.":   {dns.TypeA},
+		"b.c.example.": {dns.TypeTXT},
+		"c.example.":   {},
+		"sub.example.": {dns.TypeNS},
+		"w.example.":   {},
+		"*.w.example.": {dns.TypeTXT},
+	}
+	nsec3 := NewTestNsec3Chain("example.", false, 0, names)
+	optOut := NewTestNsec3Chain("example.", true, 0, names)
+
+	tests := []struct {
+		name     string
+		rcode    int
+		dnsType  uint16
+		optOut   bool
+		valid    bool
+		insecure bool
+	}{
+		{"zzz.example.", dns.RcodeNameError, dns.TypeA, false, true, false},
+		{"aa.example.", dns.RcodeNameError, dns.TypeA, false, true, false},
+		{"x.y.zzz.example.", dns.RcodeNameError, dns.TypeA, false, true, false},
+		{"c.example.", dns.RcodeNameError, dns.TypeA, false, false, false},     // empty non-terminal
+		{"a.example.", dns.RcodeNameError, dns.TypeA, false, false, false},     // exists
+		{"x.sub.example.", dns.RcodeNameError, dns.TypeA, false, false, false}, // below a delegation
+		{"x.w.example.", dns.RcodeNameError, dns.TypeA, false, false, false},   // the wildcard exists
+		{"a.example.", dns.RcodeSuccess, dns.TypeMX, false, true, false},
+		{"a.example.", dns.RcodeSuccess, dns.TypeA, false, false, false},
+		{"c.example.", dns.RcodeSuccess, dns.TypeA, false, true, false},    // empty non-terminal
+		{"sub.example.", dns.RcodeSuccess, dns.TypeDS, false, true, false}, // insecure delegation
+		{"sub.example.", dns.RcodeSuccess, dns.TypeA, false, false, false},
+		{"example.", dns.RcodeSuccess, dns.TypeDS, false, false, false}, // child side of the zone cut
+		{"zzz.example.", dns.RcodeSuccess, dns.TypeA, false, false, false},
+		{"x.w.example.", dns.RcodeSuccess, dns.TypeA, false, true, false}, // wildcard no data
+		{"x.w.example.", dns.RcodeSuccess, dns.TypeTXT, false, false, false},
+		{"unsigned.example.", dns.RcodeSuccess, dns.TypeDS, true, true, false},   // unsigned delegation of the opt-out span
+		{"unsigned.example.", dns.RcodeSuccess, dns.TypeDS, false, false, false}, // no opt-out span
+		{"unsigned.example.", dns.RcodeSuccess, dns.TypeA, true, false, false},
+		{"nope.example.", dns.RcodeNameError, dns.TypeA, true, false, true}, // may be an unsigned delegation
+	}
 
 	for _, tt := range tests {
-		r, err := resolver.Query(tt.name, tt.dnsType)
-		if err != nil {
-			t.Fatalf("received error: %v", err.Error())
+
+		chain := nsec3
+		if tt.optOut {
+			chain = optOut
 		}
-		if len(r.GetRR()) < 1 {
-			t.Fatalf("RR not present")
+
+		var err error
+		if tt.rcode == dns.RcodeNameError {
+			err = Nsec3ProvesNameError(tt.name, chain)
+		} else {
+			err = Nsec3ProvesNoData(tt.name, tt.dnsType, chain)
+		}
+
+		if tt.valid && err != nil {
+			t.Fatalf("%s %s: received error: %v", tt.name, dns.TypeToString[tt.dnsType], err.Error())
 		}
-		if r.GetRRSIG() == nil {
-			t.Fatalf("RRSIG not present")
+		if !tt.valid && err == nil {
+			t.Fatalf("%s %s: not received any error", tt.name, dns.TypeToString[tt.dnsType])
 		}
+		if err != nil && errors.Is(err, ErrInsecureDelegation) != tt.insecure {
+			t.Fatalf("%s %s: expect insecure=%v: %v", tt.name, dns.TypeToString[tt.dnsType], tt.insecure, err.Error())
+		}
+	}
 
-		err = validator.Verify(r)
-		if err == nil {
-			t.Fatalf("not received any error")
+	// the denial of the DS proves an insecure delegation, the records of the chain being in any order.
+	for _, tt := range []struct {
+		name       string
+		chain      []*dns.NSEC3
+		delegation bool
+	}{
+		{"sub.example.", nsec3, true},
+		{"unsigned.example.", optOut, true},
+		{"a.example.", nsec3, false},
+	} {
+		m := h.Msg(tt.name, dns.TypeDS, dns.ClassINET)
+		for i := range tt.chain {
+			m.Ns = append(m.Ns, tt.chain[len(tt.chain)-1-i])
+		}
+		if DenialIsDelegation(model.NewDnsMsg(m)) != tt.delegation {
+			t.Fatalf("%s: expect delegation=%v", tt.name, tt.delegation)
 		}
+	}
 
-		t.Logf("received error: %v", err.Error())
+	t.Logf("Success !")
+}
+
+func TestVerifyNsec(t *testing.T) {
+
+	transverse.SetTest()
+
+	key, signer := NewTestZoneKey(t, "example.")
+	keys := new(dns.Msg)
+	keys.Answer = []dns.RR{key}
+
+	soa := MustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600")
+	apex := MustRR(t, "example. 3600 IN NSEC a.example. SOA NS RRSIG NSEC DNSKEY")
+	a := MustRR(t, "a.example. 3600 IN NSEC example. A RRSIG NSEC")
+
+	m := new(dns.Msg)
+	m.SetQuestion("b.example.", dns.TypeA)
+	m.Rcode = dns.RcodeNameError
+	m.Ns = []dns.RR{
+		soa, SignTestRRset(t, key, signer, soa),
+		apex, SignTestRRset(t, key, signer, apex),
+		a, SignTestRRset(t, key, signer, a),
+	}
+
+	if err := VerifyDenial(model.NewDnsMsg(m), model.NewDnsMsg(keys), time.Now()); err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+
+	// the NSEC records must be signed
+	m.Ns = []dns.RR{soa, apex, a}
+	if err := VerifyDenial(model.NewDnsMsg(m), model.NewDnsMsg(keys), time.Now()); err == nil {
+		t.Fatalf("not received any error")
 	}
 
 	t.Logf("Success !")
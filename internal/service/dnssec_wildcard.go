@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	"golang-dns/internal/model"
+	t "golang-dns/internal/transverse"
+	"strings"
+	"time"
+)
+
+// WildcardEncloser returns the closest encloser of a RRset expanded from a wildcard,
+// that is the owner of the wildcard without its "*" label, or "" when the RRset is not expanded (RFC 4035 §5.3.2).
+func WildcardEncloser(rrset model.DnsRRset) string {
+
+	labels := dns.CountLabel(rrset.Name)
+	if strings.HasPrefix(rrset.Name, "*.") {
+		labels-- // the wildcard itself has been queried
+	}
+
+	for _, rrsig := range rrset.Signatures {
+		if int(rrsig.Labels) < labels {
+			return h.SubZone(rrset.Name, int(rrsig.Labels))
+		}
+	}
+
+	return ""
+}
+
+// VerifyWildcard verifies that a RRset expanded from a wildcard comes with the proof that
+// no closer name exists: an NSEC or NSEC3 record covering the next closer name (RFC 4035 §5.3.4, RFC 5155 §8.8).
+// Only the NSEC/NSEC3 records signed by the zone of the RRset prove it, the records of other signers are ignored.
+func VerifyWildcard(m model.DnsMsg, rrset model.DnsRRset, keys model.DnsMsg, now time.Time) error {
+
+	encloser := WildcardEncloser(rrset)
+	if encloser == "" {
+		return nil
+	}
+
+	nextCloser := h.SubZone(rrset.Name, dns.CountLabel(encloser)+1)
+	t.LogDnssec("%s expanded from wildcard of %s, next closer name: %s", rrset, encloser, nextCloser)
+
+	nsec, nsec3, err := signedDenial(m, rrset.Signatures[0].SignerName, keys, now)
+	if err != nil {
+		return err
+	}
+
+	if len(nsec3) > 0 {
+		nsec3, err := SupportedNsec3(nsec3)
+		if err != nil {
+			return err
+		}
+		if !Nsec3Covers(nextCloser, nsec3) {
+			return fmt.Errorf("NSEC3 does not cover next closer name of wildcard: %s", nextCloser)
+		}
+		return nil
+	}
+
+	cover := NsecCovering(nextCloser, nsec)
+	if cover == nil {
+		return fmt.Errorf("NSEC does not cover next closer name of wildcard: %s", nextCloser)
+	}
+	// names below the next closer name would make it an empty non-terminal
+	if dns.IsSubDomain(nextCloser, cover.NextDomain) {
+		return fmt.Errorf("NSEC proves next closer name of wildcard exists: %s", nextCloser)
+	}
+
+	return nil
+}
+
+// signedDenial returns the NSEC and NSEC3 records of the authority section signed by signer, once verified with its keys.
+func signedDenial(m model.DnsMsg, signer string, keys model.DnsMsg, now time.Time) ([]*dns.NSEC, []*dns.NSEC3, error) {
+
+	nsec := make([]*dns.NSEC, 0)
+	nsec3 := make([]*dns.NSEC3, 0)
+
+	for _, rrset := range model.NewDnsRRsets(m.GetMsg().Ns) {
+
+		if rrset.Type != dns.TypeNSEC && rrset.Type != dns.TypeNSEC3 {
+			continue
+		}
+		if len(rrset.Signatures) == 0 || !strings.EqualFold(rrset.Signatures[0].SignerName, signer) {
+			t.LogDnssec("%s ignored: not signed by %s", rrset, signer)
+			continue
+		}
+
+		if err := VerifyRRset(keys, rrset, now); err != nil {
+			return nil, nil, err
+		}
+
+		for _, rr := range rrset.RR {
+			switch v := rr.(type) {
+			case *dns.NSEC:
+				nsec = append(nsec, v)
+			case *dns.NSEC3:
+				nsec3 = append(nsec3, v)
+			}
+		}
+	}
+
+	return nsec, nsec3, nil
+}
@@ -41,10 +41,8 @@ func (rt HardenedResty) newRestyClient(serverName, rootCertPemFile string, ip ne
 			MinVersion:             tls.VersionTLS13,
 			SessionTicketsDisabled: false,
 			InsecureSkipVerify:     false,
-			Time: func() time.Time {
-				//TODO return RoughTime
-				return time.Now()
-			},
+			// certificates are verified with the shared clock, synchronized with Roughtime servers.
+			Time: t.Now,
 			VerifyConnection: func(state tls.ConnectionState) error {
 
 				if err := h.VerifyConnection(serverName, state); err != nil {
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	h "golang-dns/internal/helpers"
+	t "golang-dns/internal/transverse"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRoughtimeInterval = time.Hour
+	DefaultRoughtimeTimeout  = 5 * time.Second
+	DefaultRoughtimeQuorum   = 2
+	DefaultRoughtimeSpread   = 10 * time.Second // maximum disagreement between the servers, beyond their radius.
+
+	roughtimeMaxResponseSize = 4096
+)
+
+// RoughtimeServer is a Roughtime server along with its long-term public key.
+type RoughtimeServer struct {
+	Name      string
+	Address   string
+	PublicKey ed25519.PublicKey
+}
+
+// NewRoughtimeServer parses a server formatted as name=host:port=base64 public key.
+func NewRoughtimeServer(s string) (RoughtimeServer, error) {
+
+	parts := strings.Split(s, "=")
+	if len(parts) < 3 {
+		return RoughtimeServer{}, fmt.Errorf("invalid roughtime server, expected name=host:port=key: %s", s)
+	}
+
+	// the padding of the base64 key is made of '=' as well.
+	key, err := base64.StdEncoding.DecodeString(strings.Join(parts[2:], "="))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return RoughtimeServer{}, fmt.Errorf("invalid roughtime public key: %s", s)
+	}
+
+	return RoughtimeServer{Name: parts[0], Address: parts[1], PublicKey: key}, nil
+}
+
+func DefaultRoughtimeServers() []RoughtimeServer {
+	servers := make([]RoughtimeServer, 0, 3)
+	for _, s := range []string{
+		"Google=roughtime.sandbox.google.com:2002=etPaaIxcBMY1oUeGpwvPMCJMwlRVNxv51KK/tktoJTQ=",
+		"Cloudflare=roughtime.cloudflare.com:2002=gD63hSj3ScS+wuOeGrubXlq35N1c5Lby/S+T7MNTjxo=",
+		"int08h=roughtime.int08h.com:2002=AW5uAoTSTDfG5NfY1bTh08GUnOqlRb+HVhbJ3ODJvsE=",
+	} {
+		server, err := NewRoughtimeServer(s)
+		if err != nil {
+			panic(err)
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+type RoughtimeConfig struct {
+	Servers  []RoughtimeServer
+	Quorum   int           // minimum number of servers which must answer and agree.
+	Spread   time.Duration // maximum disagreement between the servers.
+	Interval time.Duration // interval between two synchronizations.
+	Timeout  time.Duration
+	Resolver DnsResolver // resolves the names of the servers, the host resolver is used when nil.
+}
+
+func DefaultRoughtimeConfig() RoughtimeConfig {
+	return RoughtimeConfig{
+		Servers:  DefaultRoughtimeServers(),
+		Quorum:   DefaultRoughtimeQuorum,
+		Spread:   DefaultRoughtimeSpread,
+		Interval: DefaultRoughtimeInterval,
+		Timeout:  DefaultRoughtimeTimeout,
+	}
+}
+
+// RoughtimeClock is the host clock corrected by the offset measured with a quorum of Roughtime servers.
+// The host clock is used as is until the first synchronization succeeds.
+type RoughtimeClock struct {
+	conf   RoughtimeConfig
+	mu     *sync.RWMutex
+	offset *time.Duration
+	synced *bool
+	done   chan struct{}
+}
+
+func NewRoughtimeClock(conf RoughtimeConfig) RoughtimeClock {
+	var c RoughtimeClock
+	defer t.Logger().Printf("%s initialized", &c)
+	c.conf = conf
+	c.mu = new(sync.RWMutex)
+	c.offset = new(time.Duration)
+	c.synced = new(bool)
+	c.done = make(chan struct{})
+	return c
+}
+
+// Now implements transverse.Clock
+func (c RoughtimeClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Now().Add(*c.offset)
+}
+
+// IsSynced tells whether the clock has been synchronized at least once.
+func (c RoughtimeClock) IsSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return *c.synced
+}
+
+// ContinuouslySync periodically synchronizes the clock until Close is called.
+func (c RoughtimeClock) ContinuouslySync() {
+	go func() {
+		ticker := time.NewTicker(c.conf.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Sync(); err != nil {
+					t.LoggerError().Printf("unable to synchronize roughtime clock: %s", err.Error())
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Sync queries every server and keeps the median offset, when a quorum of them answers and agrees.
+func (c RoughtimeClock) Sync() error {
+
+	type measure struct {
+		server RoughtimeServer
+		offset time.Duration
+		radius time.Duration
+		err    error
+	}
+
+	results := make(chan measure, len(c.conf.Servers))
+	for _, server := range c.conf.Servers {
+		go func(server RoughtimeServer) {
+			offset, radius, err := c.query(server)
+			results <- measure{server: server, offset: offset, radius: radius, err: err}
+		}(server)
+	}
+
+	measures := make([]measure, 0, len(c.conf.Servers))
+	for range c.conf.Servers {
+		m := <-results
+		if m.err != nil {
+			t.LoggerError().Printf("roughtime %s: %s", m.server.Name, m.err.Error())
+			continue
+		}
+		measures = append(measures, m)
+	}
+
+	if len(measures) < c.conf.Quorum {
+		return fmt.Errorf("roughtime quorum not reached: %d/%d servers answered", len(measures), c.conf.Quorum)
+	}
+
+	sort.Slice(measures, func(i, j int) bool {
+		return measures[i].offset < measures[j].offset
+	})
+	median := measures[len(measures)/2]
+
+	// the servers which agree with the median, within their radius.
+	agree := 0
+	for _, m := range measures {
+		diff := m.offset - median.offset
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= m.radius+median.radius+c.conf.Spread {
+			agree++
+		} else {
+			t.LoggerError().Printf("roughtime %s disagrees with the other servers by %s", m.server.Name, diff)
+		}
+	}
+
+	if agree < c.conf.Quorum {
+		return fmt.Errorf("roughtime quorum not reached: %d/%d servers agree", agree, c.conf.Quorum)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.offset = median.offset
+	*c.synced = true
+
+	t.Logger().Printf("roughtime clock synchronized: offset=%s radius=%s servers=%d", median.offset, median.radius, agree)
+
+	return nil
+}
+
+// query returns the offset of the host clock measured with a server, and the uncertainty of the measure.
+func (c RoughtimeClock) query(server RoughtimeServer) (time.Duration, time.Duration, error) {
+
+	nonce := make([]byte, h.RoughtimeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, 0, err
+	}
+
+	request, err := h.NewRoughtimeRequest(nonce)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	address, err := c.resolve(server.Address)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	conn, err := net.DialTimeout("udp", address, c.conf.Timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.conf.Timeout)); err != nil {
+		return 0, 0, err
+	}
+
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, 0, err
+	}
+
+	b := make([]byte, roughtimeMaxResponseSize)
+	n, err := conn.Read(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	received := time.Now()
+
+	reply, err := h.VerifyRoughtimeResponse(b[:n], nonce, server.PublicKey)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the server told the time at some point of the round trip.
+	rtt := received.Sub(sent)
+	local := sent.Add(rtt / 2)
+
+	return reply.Midpoint.Sub(local), reply.Radius + rtt/2, nil
+}
+
+// resolve returns the address of a server with the IPv4 address of its host.
+func (c RoughtimeClock) resolve(address string) (string, error) {
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	if c.conf.Resolver == nil || net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	rm, err := c.conf.Resolver.Query(dns.Fqdn(host), dns.TypeA)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve %s: %w", host, err)
+	}
+
+	for _, rr := range rm.GetMsg().Answer {
+		if a, ok := rr.(*dns.A); ok {
+			return net.JoinHostPort(a.A.String(), port), nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to resolve %s: no address", host)
+}
+
+func (c RoughtimeClock) Close() {
+	close(c.done)
+}
+
+func (c RoughtimeClock) String() string {
+	names := make([]string, 0, len(c.conf.Servers))
+	for _, s := range c.conf.Servers {
+		names = append(names, s.Name)
+	}
+	return fmt.Sprintf("RoughtimeClock servers=%v quorum=%d", names, c.conf.Quorum)
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	h "golang-dns/internal/helpers"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// NewTestRoughtimeServer serves on a local UDP port the time shifted by offset, signed with a generated key.
+// A forged server signs its responses with a key which is not the one published.
+func NewTestRoughtimeServer(t *testing.T, name string, offset time.Duration, forged bool) RoughtimeServer {
+
+	le32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	}
+	le64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+	encode := func(msg map[uint32][]byte) []byte {
+		b, err := h.EncodeRoughtimeMessage(msg)
+		if err != nil {
+			t.Fatalf("received error: %v", err.Error())
+		}
+		return b
+	}
+
+	published, root, _ := ed25519.GenerateKey(nil)
+	if forged {
+		_, root, _ = ed25519.GenerateKey(nil)
+	}
+	delegated, online, _ := ed25519.GenerateKey(nil)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	dele := encode(map[uint32][]byte{h.TagMINT: le64(0), h.TagMAXT: le64(math.MaxUint64), h.TagPUBK: delegated})
+	cert := encode(map[uint32][]byte{h.TagDELE: dele, h.TagSIG: ed25519.Sign(root, append([]byte("RoughTime v1 delegation signature--\x00"), dele...))})
+
+	go func() {
+		b := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			req, err := h.DecodeRoughtimeMessage(b[:n])
+			if err != nil || n < h.RoughtimeRequestSize {
+				continue
+			}
+
+			// the nonce is the left leaf of a tree of two requests.
+			sibling := h.RoughtimeLeafHash(make([]byte, h.RoughtimeNonceSize))
+			srep := encode(map[uint32][]byte{
+				h.TagRADI: le32(uint32(time.Second / time.Microsecond)),
+				h.TagMIDP: le64(uint64(time.Now().Add(offset).UnixMicro())),
+				h.TagROOT: h.RoughtimeNodeHash(h.RoughtimeLeafHash(req[h.TagNONC]), sibling),
+			})
+			resp := encode(map[uint32][]byte{
+				h.TagSIG:  ed25519.Sign(online, append([]byte("RoughTime v1 response signature\x00"), srep...)),
+				h.TagPATH: sibling,
+				h.TagSREP: srep,
+				h.TagCERT: cert,
+				h.TagINDX: le32(0),
+			})
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return RoughtimeServer{Name: name, Address: conn.LocalAddr().String(), PublicKey: published}
+}
+
+func TestRoughtimeClock(t *testing.T) {
+
+	offset := time.Hour
+
+	tests := []struct {
+		name    string
+		servers []RoughtimeServer
+		valid   bool
+	}{
+		{"quorum", []RoughtimeServer{
+			NewTestRoughtimeServer(t, "a", offset, false),
+			NewTestRoughtimeServer(t, "b", offset, false),
+			NewTestRoughtimeServer(t, "forged", 0, true),
+		}, true},
+		{"false ticker", []RoughtimeServer{
+			NewTestRoughtimeServer(t, "a", offset, false),
+			NewTestRoughtimeServer(t, "b", offset, false),
+			NewTestRoughtimeServer(t, "false", 0, false),
+		}, true},
+		{"forged signatures", []RoughtimeServer{
+			NewTestRoughtimeServer(t, "a", offset, false),
+			NewTestRoughtimeServer(t, "forged", offset, true),
+		}, false},
+		{"disagreement", []RoughtimeServer{
+			NewTestRoughtimeServer(t, "a", offset, false),
+			NewTestRoughtimeServer(t, "b", 0, false),
+		}, false},
+		{"no answer", []RoughtimeServer{
+			NewTestRoughtimeServer(t, "a", offset, false),
+			{Name: "down", Address: "127.0.0.1:9", PublicKey: make([]byte, ed25519.PublicKeySize)},
+		}, false},
+	}
+
+	for _, tt := range tests {
+
+		conf := DefaultRoughtimeConfig()
+		conf.Servers = tt.servers
+		conf.Timeout = time.Second
+		clock := NewRoughtimeClock(conf)
+
+		err := clock.Sync()
+		if tt.valid && err != nil {
+			t.Fatalf("%s: received error: %v", tt.name, err.Error())
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s: not received any error", tt.name)
+		}
+		if clock.IsSynced() != tt.valid {
+			t.Fatalf("%s: expect synced=%v", tt.name, tt.valid)
+		}
+
+		expected := time.Now()
+		if tt.valid {
+			expected = expected.Add(offset)
+		}
+		if diff := clock.Now().Sub(expected); diff > time.Second || diff < -time.Second {
+			t.Fatalf("%s: clock is off by %s", tt.name, diff)
+		}
+	}
+
+	t.Logf("Success !")
+}
+
+func TestRoughtimeMessage(t *testing.T) {
+
+	nonce := make([]byte, h.RoughtimeNonceSize)
+	nonce[0] = 1
+
+	b, err := h.NewRoughtimeRequest(nonce)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if len(b) != h.RoughtimeRequestSize {
+		t.Fatalf("expected a padded request, received %d bytes", len(b))
+	}
+
+	msg, err := h.DecodeRoughtimeMessage(b)
+	if err != nil {
+		t.Fatalf("received error: %v", err.Error())
+	}
+	if string(msg[h.TagNONC]) != string(nonce) {
+		t.Fatalf("nonce not decoded")
+	}
+
+	// truncated and out of bounds messages.
+	for _, invalid := range [][]byte{b[:3], b[:12], {2, 0, 0, 0, 0xff, 0xff, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}, {2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0}} {
+		if _, err := h.DecodeRoughtimeMessage(invalid); err == nil {
+			t.Fatalf("%v: not received any error", invalid)
+		}
+	}
+
+	t.Logf("Success !")
+}
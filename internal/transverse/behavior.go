@@ -1,5 +1,7 @@
 package transverse
 
+import "sync/atomic"
+
 const (
 	defaultRetryCount = 1
 )
@@ -10,12 +12,22 @@ var (
 	FlagHttpEnableTrace = false
 
 	retry = defaultRetryCount
+	ready int32
 )
 
 func GetRetry() int {
 	return retry
 }
 
+// SetReady marks the server as ready to answer queries, once its start-up phase is completed.
+func SetReady() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
 func SetTest() {
 
 	FlagLogHttpsCerts = false
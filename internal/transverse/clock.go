@@ -0,0 +1,48 @@
+package transverse
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time used to verify certificates and signatures.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the time of the host.
+type SystemClock struct{}
+
+func (_ SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock always tells the same time, ex: to validate recorded responses.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+var (
+	clockMu       = new(sync.RWMutex)
+	clock   Clock = SystemClock{}
+)
+
+// SetClock replaces the shared clock, ex: by a clock synchronized with Roughtime servers.
+func SetClock(c Clock) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	clock = c
+}
+
+func GetClock() Clock {
+	clockMu.RLock()
+	defer clockMu.RUnlock()
+	return clock
+}
+
+// Now returns the time of the shared clock.
+func Now() time.Time {
+	return GetClock().Now()
+}
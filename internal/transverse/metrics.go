@@ -0,0 +1,19 @@
+package transverse
+
+import "expvar"
+
+// metrics are published with expvar, see the /metrics route of the admin server.
+var (
+	dnssecMetrics    = expvar.NewMap("dnssec")
+	rateLimitMetrics = expvar.NewMap("ratelimit")
+)
+
+// CountDnssec counts the responses by DNSSEC validation status.
+func CountDnssec(status string) {
+	dnssecMetrics.Add(status, 1)
+}
+
+// CountRateLimited counts the queries exceeding the rate limits by action taken.
+func CountRateLimited(action string) {
+	rateLimitMetrics.Add(action, 1)
+}